@@ -0,0 +1,83 @@
+// Command loadgen publishes order events to Kafka at a target rate and reports the
+// throughput and publish latency. Its own flags come first, the application configuration
+// is loaded from the arguments after --, e.g.
+//
+//	loadgen -rate 5000 -duration 1m -keys zipf -- -config config.example.yaml -kafka.client sarama
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"goEvents/internal/config"
+	"goEvents/internal/infrastructure/messaging"
+	"goEvents/internal/loadgen"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	logrus.SetLevel(logrus.InfoLevel)
+
+	load := loadgen.DefaultConfig()
+	flag.Float64Var(&load.Rate, "rate", load.Rate, "target events per second, 0 for no limit")
+	flag.IntVar(&load.Count, "count", load.Count, "events to publish, 0 for no limit")
+	flag.DurationVar(&load.Duration, "duration", load.Duration, "maximum duration of the run, 0 for no limit")
+	flag.IntVar(&load.MessageSize, "size", load.MessageSize, "approximate encoded event size in bytes")
+	flag.StringVar((*string)(&load.Keys), "keys", string(load.Keys), "key distribution: unique, uniform, sequential or zipf")
+	flag.IntVar(&load.KeySpace, "key-space", load.KeySpace, "distinct keys of the uniform, sequential and zipf distributions")
+	flag.IntVar(&load.Concurrency, "concurrency", load.Concurrency, "events published in parallel")
+	flag.Parse()
+
+	if err := load.Validate(); err != nil {
+		logrus.WithError(err).Fatal("Invalid load configuration")
+	}
+
+	// The Kafka client, brokers and producer settings are the application's
+	cfg, err := config.Load(flag.Args())
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to load configuration")
+	}
+
+	producer, err := messaging.NewProducer(cfg.Kafka.Client, cfg.ProducerConfig())
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to create Kafka producer")
+	}
+
+	// SIGINT or SIGTERM ends the run early, what was published until then is still reported
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	logrus.WithFields(logrus.Fields{
+		"client":      cfg.Kafka.Client,
+		"topic":       cfg.Kafka.Producer.Topic,
+		"rate":        load.Rate,
+		"count":       load.Count,
+		"duration":    load.Duration.String(),
+		"size":        load.MessageSize,
+		"keys":        load.Keys,
+		"concurrency": load.Concurrency,
+	}).Info("Starting load run")
+
+	report, err := loadgen.Run(ctx, producer, load)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	producer.Shutdown(shutdownCtx)
+
+	if err != nil {
+		logrus.WithError(err).Fatal("Load run failed")
+	}
+	fmt.Print(report)
+
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
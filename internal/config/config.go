@@ -0,0 +1,231 @@
+// Package config loads the application configuration from a YAML file, environment
+// variables and command-line flags
+package config
+
+import (
+	"errors"
+	"fmt"
+	"goEvents/internal/infrastructure/messaging"
+	"goEvents/internal/infrastructure/persistence"
+	"time"
+)
+
+// ErrInvalidConfig is returned when the effective configuration fails validation
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Config is the configuration of the whole application
+type Config struct {
+	HTTP     HTTPConfig     `yaml:"http"`
+	Database DatabaseConfig `yaml:"database"`
+	Kafka    KafkaConfig    `yaml:"kafka"`
+}
+
+// HTTPConfig holds the settings of the API server
+type HTTPConfig struct {
+	// Addr is the host:port the server listens on
+	Addr string `yaml:"addr"`
+	// ReadHeaderTimeout bounds how long a client may take to send the request headers
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	// ShutdownTimeout bounds how long each component may take to stop
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// DrainDelay is how long /readyz reports not ready before the components stop, so
+	// load balancers stop routing requests to the server first
+	DrainDelay time.Duration `yaml:"drain_delay"`
+}
+
+// DatabaseConfig holds the MySQL connection and pool settings
+type DatabaseConfig struct {
+	// DSN is the MySQL data source name, its password is redacted when printed
+	DSN             string        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+// KafkaConfig holds the settings shared by the producer and the consumer
+type KafkaConfig struct {
+	// Client selects the Kafka client library: franz, sarama or confluent
+	Client           messaging.Client `yaml:"client"`
+	BootstrapServers string           `yaml:"bootstrap_servers"`
+	Security         SecurityConfig   `yaml:"security"`
+	Producer         ProducerConfig   `yaml:"producer"`
+	Consumer         ConsumerConfig   `yaml:"consumer"`
+}
+
+// SecurityConfig mirrors messaging.SecurityConfig
+type SecurityConfig struct {
+	TLS  TLSConfig  `yaml:"tls"`
+	SASL SASLConfig `yaml:"sasl"`
+}
+
+// TLSConfig mirrors messaging.TLSConfig
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// SASLConfig mirrors messaging.SASLConfig, the password is redacted when printed
+type SASLConfig struct {
+	Mechanism messaging.SASLMechanism `yaml:"mechanism"`
+	Username  string                  `yaml:"username"`
+	Password  string                  `yaml:"password"`
+}
+
+// ProducerConfig mirrors the producer specific fields of messaging.ProducerConfig
+type ProducerConfig struct {
+	Topic         string                `yaml:"topic"`
+	Acks          messaging.Acks        `yaml:"acks"`
+	Compression   messaging.Compression `yaml:"compression"`
+	Linger        time.Duration         `yaml:"linger"`
+	BatchMaxBytes int                   `yaml:"batch_max_bytes"`
+	Idempotent    bool                  `yaml:"idempotent"`
+	Retries       int                   `yaml:"retries"`
+	Partitioner   messaging.Partitioner `yaml:"partitioner"`
+}
+
+// ConsumerConfig mirrors the consumer specific fields of messaging.ConsumerConfig
+type ConsumerConfig struct {
+	GroupID         string               `yaml:"group_id"`
+	Topics          []string             `yaml:"topics"`
+	AutoOffsetReset string               `yaml:"auto_offset_reset"`
+	Workers         int                  `yaml:"workers"`
+	Assignor        messaging.Assignor   `yaml:"assignor"`
+	CommitMode      messaging.CommitMode `yaml:"commit_mode"`
+	BatchSize       int                  `yaml:"batch_size"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	pool := persistence.DefaultPoolConfig()
+	producer := messaging.DefaultProducerConfig()
+
+	return &Config{
+		HTTP: HTTPConfig{
+			Addr:              ":8081",
+			ReadHeaderTimeout: 10 * time.Second,
+			ShutdownTimeout:   10 * time.Second,
+		},
+		Database: DatabaseConfig{
+			DSN:             "kafka:kafka@tcp(127.0.0.1:3306)/db?charset=utf8mb4&parseTime=True&loc=Local",
+			MaxOpenConns:    pool.MaxOpenConns,
+			MaxIdleConns:    pool.MaxIdleConns,
+			ConnMaxLifetime: pool.ConnMaxLifetime,
+			ConnMaxIdleTime: pool.ConnMaxIdleTime,
+		},
+		Kafka: KafkaConfig{
+			Client:           messaging.ClientFranz,
+			BootstrapServers: producer.BootstrapServers,
+			Producer: ProducerConfig{
+				Topic:         producer.Topic,
+				Acks:          producer.Acks,
+				Compression:   producer.Compression,
+				Linger:        producer.Linger,
+				BatchMaxBytes: producer.BatchMaxBytes,
+				Idempotent:    producer.Idempotent,
+				Retries:       producer.Retries,
+				Partitioner:   producer.Partitioner,
+			},
+			Consumer: ConsumerConfig{
+				GroupID:         "order.group",
+				Topics:          []string{"orders"},
+				AutoOffsetReset: "earliest",
+				Workers:         10,
+			},
+		},
+	}
+}
+
+// Validate checks the configuration, including the producer and consumer settings
+func (c *Config) Validate() error {
+	if c.HTTP.Addr == "" {
+		return fmt.Errorf("%w: http.addr is required", ErrInvalidConfig)
+	}
+	if c.HTTP.ReadHeaderTimeout <= 0 || c.HTTP.ShutdownTimeout <= 0 {
+		return fmt.Errorf("%w: http timeouts must be positive", ErrInvalidConfig)
+	}
+	if c.HTTP.DrainDelay < 0 {
+		return fmt.Errorf("%w: http.drain_delay must not be negative", ErrInvalidConfig)
+	}
+
+	if c.Database.DSN == "" {
+		return fmt.Errorf("%w: database.dsn is required", ErrInvalidConfig)
+	}
+	if c.Database.MaxOpenConns <= 0 || c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		return fmt.Errorf("%w: database pool needs 0 <= max_idle_conns <= max_open_conns and max_open_conns > 0", ErrInvalidConfig)
+	}
+
+	if _, err := messaging.ParseClient(string(c.Kafka.Client)); err != nil {
+		return fmt.Errorf("%w: kafka.client: %w", ErrInvalidConfig, err)
+	}
+	if err := c.ProducerConfig().Validate(); err != nil {
+		return fmt.Errorf("%w: kafka.producer: %w", ErrInvalidConfig, err)
+	}
+	if err := messaging.CheckConsumer(c.Kafka.Client, c.ConsumerConfig()); err != nil {
+		return fmt.Errorf("%w: kafka.consumer: %w", ErrInvalidConfig, err)
+	}
+
+	return nil
+}
+
+// PoolConfig returns the database pool settings for the repositories
+func (c *Config) PoolConfig() persistence.DBPoolConfig {
+	return persistence.DBPoolConfig{
+		MaxOpenConns:    c.Database.MaxOpenConns,
+		MaxIdleConns:    c.Database.MaxIdleConns,
+		ConnMaxLifetime: c.Database.ConnMaxLifetime,
+		ConnMaxIdleTime: c.Database.ConnMaxIdleTime,
+	}
+}
+
+// ProducerConfig returns the settings of the order event producer
+func (c *Config) ProducerConfig() *messaging.ProducerConfig {
+	return &messaging.ProducerConfig{
+		BootstrapServers: c.Kafka.BootstrapServers,
+		Security:         c.security(),
+		Topic:            c.Kafka.Producer.Topic,
+		Acks:             c.Kafka.Producer.Acks,
+		Compression:      c.Kafka.Producer.Compression,
+		Linger:           c.Kafka.Producer.Linger,
+		BatchMaxBytes:    c.Kafka.Producer.BatchMaxBytes,
+		Idempotent:       c.Kafka.Producer.Idempotent,
+		Retries:          c.Kafka.Producer.Retries,
+		Partitioner:      c.Kafka.Producer.Partitioner,
+	}
+}
+
+// ConsumerConfig returns the settings of the order event consumer
+func (c *Config) ConsumerConfig() *messaging.ConsumerConfig {
+	return &messaging.ConsumerConfig{
+		BootstrapServers: c.Kafka.BootstrapServers,
+		Security:         c.security(),
+		GroupID:          c.Kafka.Consumer.GroupID,
+		Topics:           c.Kafka.Consumer.Topics,
+		AutoOffsetReset:  c.Kafka.Consumer.AutoOffsetReset,
+		Workers:          c.Kafka.Consumer.Workers,
+		Assignor:         c.Kafka.Consumer.Assignor,
+		CommitMode:       c.Kafka.Consumer.CommitMode,
+		BatchSize:        c.Kafka.Consumer.BatchSize,
+	}
+}
+
+// security maps the Kafka security settings shared by the producer and the consumer
+func (c *Config) security() messaging.SecurityConfig {
+	return messaging.SecurityConfig{
+		TLS: messaging.TLSConfig{
+			Enabled:            c.Kafka.Security.TLS.Enabled,
+			CAFile:             c.Kafka.Security.TLS.CAFile,
+			CertFile:           c.Kafka.Security.TLS.CertFile,
+			KeyFile:            c.Kafka.Security.TLS.KeyFile,
+			InsecureSkipVerify: c.Kafka.Security.TLS.InsecureSkipVerify,
+		},
+		SASL: messaging.SASLConfig{
+			Mechanism: c.Kafka.Security.SASL.Mechanism,
+			Username:  c.Kafka.Security.SASL.Username,
+			Password:  c.Kafka.Security.SASL.Password,
+		},
+	}
+}
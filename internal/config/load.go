@@ -0,0 +1,300 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// envPrefix prefixes the environment variable of every setting
+	envPrefix = "GOEVENTS_"
+	// configFileEnv names the YAML file when the -config flag is not given
+	configFileEnv = envPrefix + "CONFIG"
+	// redacted replaces secrets when the configuration is printed
+	redacted = "REDACTED"
+)
+
+// field is one setting that can be overridden by an environment variable and a flag.
+// Its key is the dotted YAML path, e.g. kafka.producer.batch_max_bytes, which is set
+// with GOEVENTS_KAFKA_PRODUCER_BATCH_MAX_BYTES or -kafka.producer.batch-max-bytes.
+type field struct {
+	key    string
+	usage  string
+	isBool bool
+	get    func(c *Config) string
+	set    func(c *Config, value string) error
+	// redact hides the secret parts of the value when printed, nil for plain values
+	redact func(value string) string
+}
+
+// fields lists every overridable setting
+var fields = []field{
+	stringField("http.addr", "host:port of the API server", func(c *Config) *string { return &c.HTTP.Addr }),
+	durationField("http.read_header_timeout", "time allowed to read request headers", func(c *Config) *time.Duration { return &c.HTTP.ReadHeaderTimeout }),
+	durationField("http.shutdown_timeout", "time each component gets to stop", func(c *Config) *time.Duration { return &c.HTTP.ShutdownTimeout }),
+	durationField("http.drain_delay", "time /readyz reports not ready before shutdown", func(c *Config) *time.Duration { return &c.HTTP.DrainDelay }),
+
+	secret(stringField("database.dsn", "MySQL data source name", func(c *Config) *string { return &c.Database.DSN }), redactDSN),
+	intField("database.max_open_conns", "maximum open database connections", func(c *Config) *int { return &c.Database.MaxOpenConns }),
+	intField("database.max_idle_conns", "maximum idle database connections", func(c *Config) *int { return &c.Database.MaxIdleConns }),
+	durationField("database.conn_max_lifetime", "maximum lifetime of a database connection", func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime }),
+	durationField("database.conn_max_idle_time", "maximum idle time of a database connection", func(c *Config) *time.Duration { return &c.Database.ConnMaxIdleTime }),
+
+	stringField("kafka.client", "Kafka client: franz, sarama or confluent", func(c *Config) *string { return (*string)(&c.Kafka.Client) }),
+	stringField("kafka.bootstrap_servers", "comma-separated Kafka brokers", func(c *Config) *string { return &c.Kafka.BootstrapServers }),
+	boolField("kafka.security.tls.enabled", "connect to Kafka with TLS", func(c *Config) *bool { return &c.Kafka.Security.TLS.Enabled }),
+	stringField("kafka.security.tls.ca_file", "PEM file of the CAs signing the broker certificates", func(c *Config) *string { return &c.Kafka.Security.TLS.CAFile }),
+	stringField("kafka.security.tls.cert_file", "PEM client certificate for mutual TLS", func(c *Config) *string { return &c.Kafka.Security.TLS.CertFile }),
+	stringField("kafka.security.tls.key_file", "PEM client key for mutual TLS", func(c *Config) *string { return &c.Kafka.Security.TLS.KeyFile }),
+	boolField("kafka.security.tls.insecure_skip_verify", "skip the verification of the broker certificates", func(c *Config) *bool { return &c.Kafka.Security.TLS.InsecureSkipVerify }),
+	stringField("kafka.security.sasl.mechanism", "SASL mechanism: PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", func(c *Config) *string { return (*string)(&c.Kafka.Security.SASL.Mechanism) }),
+	stringField("kafka.security.sasl.username", "SASL username", func(c *Config) *string { return &c.Kafka.Security.SASL.Username }),
+	secret(stringField("kafka.security.sasl.password", "SASL password", func(c *Config) *string { return &c.Kafka.Security.SASL.Password }), redactAll),
+
+	stringField("kafka.producer.topic", "topic of the published order events", func(c *Config) *string { return &c.Kafka.Producer.Topic }),
+	stringField("kafka.producer.acks", "producer acks: all, leader or none", func(c *Config) *string { return (*string)(&c.Kafka.Producer.Acks) }),
+	stringField("kafka.producer.compression", "producer compression: none, gzip, snappy, lz4 or zstd", func(c *Config) *string { return (*string)(&c.Kafka.Producer.Compression) }),
+	durationField("kafka.producer.linger", "time a batch waits for more records", func(c *Config) *time.Duration { return &c.Kafka.Producer.Linger }),
+	intField("kafka.producer.batch_max_bytes", "largest produced batch in bytes", func(c *Config) *int { return &c.Kafka.Producer.BatchMaxBytes }),
+	boolField("kafka.producer.idempotent", "produce idempotently", func(c *Config) *bool { return &c.Kafka.Producer.Idempotent }),
+	intField("kafka.producer.retries", "retries of a failed produce request", func(c *Config) *int { return &c.Kafka.Producer.Retries }),
+	stringField("kafka.producer.partitioner", "partitioner: murmur2 or random", func(c *Config) *string { return (*string)(&c.Kafka.Producer.Partitioner) }),
+
+	stringField("kafka.consumer.group_id", "consumer group", func(c *Config) *string { return &c.Kafka.Consumer.GroupID }),
+	listField("kafka.consumer.topics", "comma-separated consumed topics", func(c *Config) *[]string { return &c.Kafka.Consumer.Topics }),
+	stringField("kafka.consumer.auto_offset_reset", "where to start without a committed offset: earliest or latest", func(c *Config) *string { return &c.Kafka.Consumer.AutoOffsetReset }),
+	intField("kafka.consumer.workers", "records processed in parallel", func(c *Config) *int { return &c.Kafka.Consumer.Workers }),
+	stringField("kafka.consumer.assignor", "assignor: range, roundrobin or cooperative-sticky", func(c *Config) *string { return (*string)(&c.Kafka.Consumer.Assignor) }),
+	stringField("kafka.consumer.commit_mode", "commit mode: auto, after-success or manual-batch", func(c *Config) *string { return (*string)(&c.Kafka.Consumer.CommitMode) }),
+	intField("kafka.consumer.batch_size", "records handled per batch, one disables batching", func(c *Config) *int { return &c.Kafka.Consumer.BatchSize }),
+}
+
+// Load builds the configuration from the defaults, the YAML file given with -config or
+// GOEVENTS_CONFIG, GOEVENTS_* environment variables and the flags in args, each source
+// overriding the previous ones, and validates the result
+func Load(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("go-events", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv(configFileEnv), "path of the YAML configuration file")
+
+	// Flags are recorded while parsing and applied after the file and the environment
+	type override struct {
+		field field
+		value string
+	}
+	var overrides []override
+	for _, f := range fields {
+		record := func(value string) error {
+			overrides = append(overrides, override{field: f, value: value})
+			return nil
+		}
+		if f.isBool {
+			flags.BoolFunc(flagName(f.key), f.usage, record)
+		} else {
+			flags.Func(flagName(f.key), f.usage, record)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := Default()
+
+	if *path != "" {
+		if err := config.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := os.LookupEnv(envName(f.key)); ok {
+			if err := f.set(config, value); err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrInvalidConfig, envName(f.key), err)
+			}
+		}
+	}
+
+	for _, o := range overrides {
+		if err := o.field.set(config, o.value); err != nil {
+			return nil, fmt.Errorf("%w: -%s: %w", ErrInvalidConfig, flagName(o.field.key), err)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// loadFile decodes a YAML file over the configuration, unknown keys are rejected
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, path, err)
+	}
+
+	return nil
+}
+
+// Redacted returns every setting by key with secrets redacted, e.g. for logging
+func (c *Config) Redacted() map[string]interface{} {
+	values := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		value := f.get(c)
+		if f.redact != nil && value != "" {
+			value = f.redact(value)
+		}
+		values[f.key] = value
+	}
+	return values
+}
+
+// String returns the effective configuration as sorted key=value lines with secrets redacted
+func (c *Config) String() string {
+	values := c.Redacted()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%v\n", key, values[key])
+	}
+	return b.String()
+}
+
+// envName returns the environment variable of a key
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// flagName returns the flag of a key
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// stringField creates a field for a string setting
+func stringField(key, usage string, ptr func(c *Config) *string) field {
+	return field{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return *ptr(c) },
+		set: func(c *Config, value string) error {
+			*ptr(c) = value
+			return nil
+		},
+	}
+}
+
+// intField creates a field for an integer setting
+func intField(key, usage string, ptr func(c *Config) *int) field {
+	return field{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.Itoa(*ptr(c)) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			*ptr(c) = n
+			return nil
+		},
+	}
+}
+
+// boolField creates a field for a boolean setting, a flag without value sets it to true
+func boolField(key, usage string, ptr func(c *Config) *bool) field {
+	return field{
+		key:    key,
+		usage:  usage,
+		isBool: true,
+		get:    func(c *Config) string { return strconv.FormatBool(*ptr(c)) },
+		set: func(c *Config, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			*ptr(c) = b
+			return nil
+		},
+	}
+}
+
+// durationField creates a field for a duration setting such as 500ms or 5m
+func durationField(key, usage string, ptr func(c *Config) *time.Duration) field {
+	return field{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return ptr(c).String() },
+		set: func(c *Config, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			*ptr(c) = d
+			return nil
+		},
+	}
+}
+
+// listField creates a field for a comma-separated list setting
+func listField(key, usage string, ptr func(c *Config) *[]string) field {
+	return field{
+		key:   key,
+		usage: usage,
+		get:   func(c *Config) string { return strings.Join(*ptr(c), ",") },
+		set: func(c *Config, value string) error {
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			*ptr(c) = items
+			return nil
+		},
+	}
+}
+
+// secret marks a field whose value is redacted when printed
+func secret(f field, redact func(value string) string) field {
+	f.redact = redact
+	return f
+}
+
+// redactAll hides the whole value
+func redactAll(string) string {
+	return redacted
+}
+
+// redactDSN hides the password of a MySQL DSN, or the whole DSN if it cannot be parsed
+func redactDSN(dsn string) string {
+	parsed, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return redacted
+	}
+	if parsed.Passwd != "" {
+		parsed.Passwd = redacted
+	}
+	return parsed.FormatDSN()
+}
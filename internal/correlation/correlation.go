@@ -0,0 +1,138 @@
+// Package correlation carries the correlation ID and W3C trace context of a request
+// through contexts, HTTP headers and Kafka record headers, so an order can be followed
+// from the API through the producer and consumer down to the database
+package correlation
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Header names carrying the correlation ID and trace context
+const (
+	// HTTPHeader carries the correlation ID of HTTP requests and responses
+	HTTPHeader = "X-Correlation-ID"
+	// KafkaHeader carries the correlation ID of Kafka records
+	KafkaHeader = "correlation-id"
+	// TraceParentHeader carries the W3C trace context, in HTTP and Kafka headers alike
+	TraceParentHeader = "traceparent"
+)
+
+// maxIDLength is the longest correlation ID accepted from a caller
+const maxIDLength = 64
+
+type contextKey int
+
+const (
+	idKey contextKey = iota
+	traceParentKey
+)
+
+// NewID generates a new correlation ID
+func NewID() string {
+	return uuid.New().String()
+}
+
+// ValidID reports whether a correlation ID received from a caller can be used as is
+func ValidID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// WithID returns a copy of ctx carrying the correlation ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey, id)
+}
+
+// ID returns the correlation ID carried by ctx, or an empty string
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey).(string)
+	return id
+}
+
+// WithTraceParent returns a copy of ctx carrying the trace context
+func WithTraceParent(ctx context.Context, tp TraceParent) context.Context {
+	return context.WithValue(ctx, traceParentKey, tp)
+}
+
+// TraceParentFromContext returns the trace context of ctx: the OpenTelemetry span
+// started in ctx when there is one, the trace context stored with WithTraceParent otherwise
+func TraceParentFromContext(ctx context.Context) (TraceParent, bool) {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && !sc.IsRemote() {
+		return TraceParent{
+			TraceID: sc.TraceID(),
+			SpanID:  sc.SpanID(),
+			Flags:   byte(sc.TraceFlags()),
+		}, true
+	}
+
+	tp, ok := ctx.Value(traceParentKey).(TraceParent)
+	return tp, ok
+}
+
+// WithRemoteParent returns a copy of ctx in which spans started by OpenTelemetry
+// continue the trace of a traceparent header. An invalid header leaves ctx unchanged.
+func WithRemoteParent(ctx context.Context, traceParent string) context.Context {
+	tp, err := ParseTraceParent(traceParent)
+	if err != nil {
+		return ctx
+	}
+
+	return trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tp.TraceID,
+		SpanID:     tp.SpanID,
+		TraceFlags: trace.TraceFlags(tp.Flags),
+		Remote:     true,
+	}))
+}
+
+// Continue returns a copy of ctx for a unit of work started on behalf of a caller.
+// It keeps the caller's correlation ID when it is valid, generating a new one otherwise.
+// The trace context is the span active in ctx; without one it starts a new span of
+// the caller's trace, or a new trace.
+func Continue(ctx context.Context, id, traceParent string) context.Context {
+	if !ValidID(id) {
+		id = NewID()
+	}
+	ctx = WithID(ctx, id)
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && !sc.IsRemote() {
+		return ctx
+	}
+
+	tp, err := ParseTraceParent(traceParent)
+	if err != nil {
+		tp = NewTraceParent()
+	} else {
+		tp = tp.Child()
+	}
+
+	return WithTraceParent(ctx, tp)
+}
+
+// Fields returns the logrus fields identifying the request carried by ctx
+func Fields(ctx context.Context) logrus.Fields {
+	fields := logrus.Fields{}
+	if id := ID(ctx); id != "" {
+		fields["correlation_id"] = id
+	}
+	if tp, ok := TraceParentFromContext(ctx); ok {
+		fields["trace_id"] = tp.TraceIDString()
+		fields["span_id"] = tp.SpanIDString()
+	}
+	return fields
+}
+
+// Logger returns a logrus entry with the fields identifying the request carried by ctx
+func Logger(ctx context.Context) *logrus.Entry {
+	return logrus.WithFields(Fields(ctx))
+}
@@ -0,0 +1,107 @@
+package correlation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// traceParentVersion is the version of the W3C traceparent format written by this service
+const traceParentVersion = "00"
+
+// flagSampled marks a trace as sampled by its caller
+const flagSampled byte = 0x01
+
+// ErrInvalidTraceParent is returned when a traceparent header is malformed
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+// TraceParent is a W3C trace context: the trace an operation belongs to and its span
+type TraceParent struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// NewTraceParent starts a new sampled trace
+func NewTraceParent() TraceParent {
+	tp := TraceParent{Flags: flagSampled}
+	randomID(tp.TraceID[:])
+	randomID(tp.SpanID[:])
+	return tp
+}
+
+// ParseTraceParent parses a version-00 W3C traceparent header,
+// formatted as version-traceid-spanid-flags
+func ParseTraceParent(s string) (TraceParent, error) {
+	var tp TraceParent
+
+	// Version 00 has exactly four fields, later versions may append more
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	switch {
+	case len(parts) < 4, len(parts[0]) != 2, parts[0] == "ff":
+		return tp, fmt.Errorf("%w: %q", ErrInvalidTraceParent, s)
+	case parts[0] == traceParentVersion && len(parts) != 4:
+		return tp, fmt.Errorf("%w: %q", ErrInvalidTraceParent, s)
+	}
+
+	if err := decodeID(tp.TraceID[:], parts[1]); err != nil {
+		return tp, fmt.Errorf("%w: trace ID: %v", ErrInvalidTraceParent, err)
+	}
+	if err := decodeID(tp.SpanID[:], parts[2]); err != nil {
+		return tp, fmt.Errorf("%w: span ID: %v", ErrInvalidTraceParent, err)
+	}
+
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil || len(parts[3]) != 2 {
+		return tp, fmt.Errorf("%w: flags %q", ErrInvalidTraceParent, parts[3])
+	}
+	tp.Flags = flags[0]
+
+	return tp, nil
+}
+
+// Child returns a new span of the same trace
+func (tp TraceParent) Child() TraceParent {
+	child := tp
+	randomID(child.SpanID[:])
+	return child
+}
+
+// TraceIDString returns the trace ID as lowercase hex
+func (tp TraceParent) TraceIDString() string {
+	return hex.EncodeToString(tp.TraceID[:])
+}
+
+// SpanIDString returns the span ID as lowercase hex
+func (tp TraceParent) SpanIDString() string {
+	return hex.EncodeToString(tp.SpanID[:])
+}
+
+// String formats the trace context as a version-00 traceparent header
+func (tp TraceParent) String() string {
+	return fmt.Sprintf("%s-%s-%s-%02x", traceParentVersion, tp.TraceIDString(), tp.SpanIDString(), tp.Flags)
+}
+
+// decodeID decodes a lowercase hex ID that must not be all zeros
+func decodeID(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("%q must be %d lowercase hex characters", s, hex.EncodedLen(len(dst)))
+	}
+	if _, err := hex.Decode(dst, []byte(s)); err != nil {
+		return err
+	}
+	for _, b := range dst {
+		if b != 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is all zeros", s)
+}
+
+// randomID fills an ID with random bytes
+func randomID(dst []byte) {
+	// crypto/rand.Read never fails on supported platforms
+	_, _ = rand.Read(dst)
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrOrderNotFound is returned when an order does not exist
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrInvalidTransition is returned when the lifecycle forbids a status change
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	// ErrInvalidStatus is returned when a status is not part of the lifecycle
+	ErrInvalidStatus = errors.New("invalid order status")
+	// ErrConcurrentUpdate is returned when an order changed between being read and written
+	ErrConcurrentUpdate = errors.New("order was modified concurrently")
+)
+
+// InvalidTransitionError describes a status change rejected by the order lifecycle
+type InvalidTransitionError struct {
+	OrderID uint
+	From    OrderStatus
+	To      OrderStatus
+}
+
+// Error implements the error interface
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("order %d cannot move from %q to %q", e.OrderID, e.From, e.To)
+}
+
+// Is allows errors.Is(err, ErrInvalidTransition) to match
+func (e *InvalidTransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// InvalidStatusError describes a status value that is not part of the order lifecycle
+type InvalidStatusError struct {
+	Status string
+}
+
+// Error implements the error interface
+func (e *InvalidStatusError) Error() string {
+	return fmt.Sprintf("unknown order status %q", e.Status)
+}
+
+// Is allows errors.Is(err, ErrInvalidStatus) to match
+func (e *InvalidStatusError) Is(target error) bool {
+	return target == ErrInvalidStatus
+}
@@ -1,9 +1,31 @@
 package model
 
+import "time"
+
 // Order represents an order in the domain
 type Order struct {
 	ID          uint
 	Description string
 	Quantity    int
-	Status      string
-}
\ No newline at end of file
+	Status      OrderStatus
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TransitionTo moves the order to the next status if the lifecycle allows it
+func (o *Order) TransitionTo(next OrderStatus) error {
+	if !next.IsValid() {
+		return &InvalidStatusError{Status: string(next)}
+	}
+
+	if !o.Status.CanTransitionTo(next) {
+		return &InvalidTransitionError{
+			OrderID: o.ID,
+			From:    o.Status,
+			To:      next,
+		}
+	}
+
+	o.Status = next
+	return nil
+}
@@ -0,0 +1,67 @@
+package model
+
+// OrderStatus represents a state in the order lifecycle
+type OrderStatus string
+
+const (
+	// StatusPending is the initial status of every new order
+	StatusPending OrderStatus = "pending"
+	// StatusConfirmed means the order was accepted and is awaiting payment
+	StatusConfirmed OrderStatus = "confirmed"
+	// StatusPaid means payment for the order was received
+	StatusPaid OrderStatus = "paid"
+	// StatusShipped means the order left the warehouse
+	StatusShipped OrderStatus = "shipped"
+	// StatusDelivered means the order reached the customer
+	StatusDelivered OrderStatus = "delivered"
+	// StatusCancelled means the order was cancelled before delivery
+	StatusCancelled OrderStatus = "cancelled"
+	// StatusFailed means the order could not be fulfilled
+	StatusFailed OrderStatus = "failed"
+)
+
+// orderTransitions lists the statuses each status is allowed to move to
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	StatusPending:   {StatusConfirmed, StatusCancelled, StatusFailed},
+	StatusConfirmed: {StatusPaid, StatusCancelled, StatusFailed},
+	StatusPaid:      {StatusShipped, StatusCancelled, StatusFailed},
+	StatusShipped:   {StatusDelivered, StatusFailed},
+	StatusDelivered: {},
+	StatusCancelled: {},
+	StatusFailed:    {},
+}
+
+// ParseOrderStatus converts a string into an OrderStatus, rejecting unknown values
+func ParseOrderStatus(value string) (OrderStatus, error) {
+	status := OrderStatus(value)
+	if !status.IsValid() {
+		return "", &InvalidStatusError{Status: value}
+	}
+	return status, nil
+}
+
+// IsValid reports whether the status is part of the order lifecycle
+func (s OrderStatus) IsValid() bool {
+	_, ok := orderTransitions[s]
+	return ok
+}
+
+// IsTerminal reports whether no further transitions are allowed from the status
+func (s OrderStatus) IsTerminal() bool {
+	return s.IsValid() && len(orderTransitions[s]) == 0
+}
+
+// CanTransitionTo reports whether the lifecycle allows moving from s to next
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the string representation of the status
+func (s OrderStatus) String() string {
+	return string(s)
+}
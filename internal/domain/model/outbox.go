@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// OutboxMessage is an encoded event stored in the transactional outbox until it is published
+type OutboxMessage struct {
+	ID          uint64
+	AggregateID uint
+	EventID     string
+	EventType   string
+	Payload     []byte
+	Attempts    int
+	CreatedAt   time.Time
+	// CorrelationID and TraceParent identify the request that caused the event
+	CorrelationID string
+	TraceParent   string
+}
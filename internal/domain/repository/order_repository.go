@@ -1,8 +1,73 @@
 package repository
 
-import "goEvents/internal/domain/model"
+import (
+	"context"
+	"goEvents/internal/domain/model"
+	"time"
+)
 
-// OrderRepository defines the contract for order persistence operations
+const (
+	// DefaultListLimit is the page size used when OrderFilter.Limit is not set
+	DefaultListLimit = 50
+	// MaxListLimit is the largest page size List will return
+	MaxListLimit = 1000
+)
+
+// OrderFilter narrows and paginates the orders returned by List.
+// Zero values mean "no restriction" for the filter fields.
+type OrderFilter struct {
+	// Status only returns orders currently in this status
+	Status model.OrderStatus
+	// CreatedAfter only returns orders created at or after this time
+	CreatedAfter time.Time
+	// CreatedBefore only returns orders created before this time
+	CreatedBefore time.Time
+	// Limit is the maximum number of orders to return
+	Limit int
+	// Offset is the number of matching orders to skip
+	Offset int
+}
+
+// PageLimit returns the effective page size for the filter
+func (f OrderFilter) PageLimit() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultListLimit
+	case f.Limit > MaxListLimit:
+		return MaxListLimit
+	default:
+		return f.Limit
+	}
+}
+
+// OrderRepository defines the contract for order persistence operations.
+// The context carries the correlation ID of the request, which is stored
+// with the outbox events written for the change.
 type OrderRepository interface {
-	SaveOrder(order *model.Order) error
-}
\ No newline at end of file
+	SaveOrder(ctx context.Context, order *model.Order) error
+
+	// SaveOrders persists new orders in batched multi-row inserts within one
+	// transaction, setting their generated IDs and timestamps
+	SaveOrders(ctx context.Context, orders []*model.Order) error
+
+	// FindByID returns the order with the given ID or model.ErrOrderNotFound
+	FindByID(ctx context.Context, id uint) (*model.Order, error)
+
+	// List returns the orders matching the filter ordered by ID
+	List(ctx context.Context, filter OrderFilter) ([]*model.Order, error)
+
+	// Update persists the description and quantity of an existing order and sets its
+	// status to the stored one. The status only changes through UpdateStatus.
+	Update(ctx context.Context, order *model.Order) error
+
+	// UpdateStatus moves an order from one status to another, returning
+	// model.ErrConcurrentUpdate if the stored status is no longer from
+	UpdateStatus(ctx context.Context, id uint, from, to model.OrderStatus) error
+
+	// UpdateWithStatus persists the description, quantity and status of an order in one
+	// transaction, returning model.ErrConcurrentUpdate if the stored status is no longer from
+	UpdateWithStatus(ctx context.Context, order *model.Order, from model.OrderStatus) error
+
+	// Delete removes the order with the given ID or returns model.ErrOrderNotFound
+	Delete(ctx context.Context, id uint) error
+}
@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+	"goEvents/internal/domain/model"
+)
+
+// OutboxRepository defines the contract for reading and acknowledging outbox messages.
+// Messages are written by the OrderRepository in the same transaction as the order change.
+type OutboxRepository interface {
+	// FetchPendingOutbox returns up to limit messages neither sent nor dead, ordered by ID
+	FetchPendingOutbox(ctx context.Context, limit int) ([]*model.OutboxMessage, error)
+
+	// MarkOutboxSent flags the given messages as published
+	MarkOutboxSent(ctx context.Context, ids []uint64) error
+
+	// MarkOutboxFailed records a failed publish attempt for a message
+	MarkOutboxFailed(ctx context.Context, id uint64, reason string) error
+
+	// MarkOutboxDead records why a message can never be published and stops fetching it
+	MarkOutboxDead(ctx context.Context, id uint64, reason string) error
+}
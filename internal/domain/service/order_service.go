@@ -1,11 +1,25 @@
 package service
 
 import (
+	"context"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"goEvents/internal/correlation"
 	"goEvents/internal/domain/model"
 	"goEvents/internal/domain/repository"
+	"goEvents/internal/telemetry"
 )
 
+// OrderUpdate holds the fields of an order that should change.
+// Nil fields are left untouched.
+type OrderUpdate struct {
+	Description *string
+	Quantity    *int
+	Status      *model.OrderStatus
+}
+
 // OrderService handles the business logic for orders
 type OrderService struct {
 	orderRepository repository.OrderRepository
@@ -19,20 +33,27 @@ func NewOrderService(orderRepository repository.OrderRepository) *OrderService {
 }
 
 // CreateOrder creates a new order with the given details
-func (s *OrderService) CreateOrder(description string, quantity int) (*model.Order, error) {
+func (s *OrderService) CreateOrder(ctx context.Context, description string, quantity int) (*model.Order, error) {
+	ctx, span := telemetry.Tracer().Start(ctx, "OrderService.CreateOrder")
+	defer span.End()
+
 	order := &model.Order{
 		Description: description,
 		Quantity:    quantity,
-		Status:      "pending",
+		Status:      model.StatusPending,
 	}
 
-	err := s.orderRepository.SaveOrder(order)
+	err := s.orderRepository.SaveOrder(ctx, order)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
 
+	span.SetAttributes(attribute.Int("order.id", int(order.ID)))
+
 	// Log the created order ID
-	logrus.WithFields(logrus.Fields{
+	correlation.Logger(ctx).WithFields(logrus.Fields{
 		"order_id":    order.ID,
 		"description": order.Description,
 		"quantity":    order.Quantity,
@@ -40,4 +61,155 @@ func (s *OrderService) CreateOrder(description string, quantity int) (*model.Ord
 	}).Info("Order created successfully")
 
 	return order, nil
-}
\ No newline at end of file
+}
+
+// CreateOrders creates new pending orders in one batch. Either all orders
+// are created or none are.
+func (s *OrderService) CreateOrders(ctx context.Context, orders []*model.Order) error {
+	ctx, span := telemetry.Tracer().Start(ctx, "OrderService.CreateOrders",
+		trace.WithAttributes(attribute.Int("order.count", len(orders))))
+	defer span.End()
+
+	for _, order := range orders {
+		order.Status = model.StatusPending
+	}
+
+	if err := s.orderRepository.SaveOrders(ctx, orders); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	correlation.Logger(ctx).WithField("count", len(orders)).Info("Orders created successfully")
+
+	return nil
+}
+
+// GetOrder returns the order with the given ID
+func (s *OrderService) GetOrder(ctx context.Context, id uint) (*model.Order, error) {
+	return s.orderRepository.FindByID(ctx, id)
+}
+
+// ListOrders returns the orders matching the given filter
+func (s *OrderService) ListOrders(ctx context.Context, filter repository.OrderFilter) ([]*model.Order, error) {
+	return s.orderRepository.List(ctx, filter)
+}
+
+// UpdateOrder applies a partial update to an order. A status change goes
+// through the lifecycle rules and is written together with the other fields.
+func (s *OrderService) UpdateOrder(ctx context.Context, id uint, update OrderUpdate) (*model.Order, error) {
+	if update.Description == nil && update.Quantity == nil {
+		if update.Status != nil {
+			return s.TransitionOrder(ctx, id, *update.Status)
+		}
+		return s.orderRepository.FindByID(ctx, id)
+	}
+
+	order, err := s.orderRepository.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Description != nil {
+		order.Description = *update.Description
+	}
+	if update.Quantity != nil {
+		order.Quantity = *update.Quantity
+	}
+
+	if update.Status != nil {
+		previous := order.Status
+		if err := order.TransitionTo(*update.Status); err != nil {
+			return nil, err
+		}
+		err = s.orderRepository.UpdateWithStatus(ctx, order, previous)
+	} else {
+		err = s.orderRepository.Update(ctx, order)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	correlation.Logger(ctx).WithFields(logrus.Fields{
+		"order_id":    order.ID,
+		"description": order.Description,
+		"quantity":    order.Quantity,
+		"status":      order.Status,
+	}).Info("Order updated successfully")
+
+	return order, nil
+}
+
+// DeleteOrder removes the order with the given ID
+func (s *OrderService) DeleteOrder(ctx context.Context, id uint) error {
+	if err := s.orderRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	correlation.Logger(ctx).WithField("order_id", id).Info("Order deleted successfully")
+
+	return nil
+}
+
+// TransitionOrder moves an order to the given status if the lifecycle allows it
+func (s *OrderService) TransitionOrder(ctx context.Context, id uint, status model.OrderStatus) (*model.Order, error) {
+	if !status.IsValid() {
+		return nil, &model.InvalidStatusError{Status: string(status)}
+	}
+	return s.transition(ctx, id, status)
+}
+
+// Confirm moves a pending order to confirmed
+func (s *OrderService) Confirm(ctx context.Context, id uint) (*model.Order, error) {
+	return s.transition(ctx, id, model.StatusConfirmed)
+}
+
+// Pay marks a confirmed order as paid
+func (s *OrderService) Pay(ctx context.Context, id uint) (*model.Order, error) {
+	return s.transition(ctx, id, model.StatusPaid)
+}
+
+// Ship marks a paid order as shipped
+func (s *OrderService) Ship(ctx context.Context, id uint) (*model.Order, error) {
+	return s.transition(ctx, id, model.StatusShipped)
+}
+
+// Deliver marks a shipped order as delivered
+func (s *OrderService) Deliver(ctx context.Context, id uint) (*model.Order, error) {
+	return s.transition(ctx, id, model.StatusDelivered)
+}
+
+// Cancel cancels an order that has not been shipped yet
+func (s *OrderService) Cancel(ctx context.Context, id uint) (*model.Order, error) {
+	return s.transition(ctx, id, model.StatusCancelled)
+}
+
+// Fail marks an order that could not be fulfilled as failed
+func (s *OrderService) Fail(ctx context.Context, id uint) (*model.Order, error) {
+	return s.transition(ctx, id, model.StatusFailed)
+}
+
+// transition loads the order, validates the status change and persists it
+func (s *OrderService) transition(ctx context.Context, id uint, next model.OrderStatus) (*model.Order, error) {
+	order, err := s.orderRepository.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := order.Status
+	if err := order.TransitionTo(next); err != nil {
+		return nil, err
+	}
+
+	if err := s.orderRepository.UpdateStatus(ctx, order.ID, previous, next); err != nil {
+		return nil, err
+	}
+
+	correlation.Logger(ctx).WithFields(logrus.Fields{
+		"order_id":        order.ID,
+		"previous_status": previous,
+		"status":          order.Status,
+	}).Info("Order status changed")
+
+	return order, nil
+}
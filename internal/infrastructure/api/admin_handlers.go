@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"goEvents/internal/correlation"
+	"goEvents/internal/infrastructure/messaging"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// consumerLagTimeout bounds the broker queries of one GET /admin/consumers request
+const consumerLagTimeout = 5 * time.Second
+
+// consumerResponse is the JSON representation of the lag of a consumer
+type consumerResponse struct {
+	Name       string                   `json:"name"`
+	TotalLag   int64                    `json:"total_lag"`
+	Partitions []messaging.PartitionLag `json:"partitions"`
+	Error      string                   `json:"error,omitempty"`
+}
+
+// ListConsumersHandler handles GET /admin/consumers, reporting the lag of every consumer
+func (h *Handler) ListConsumersHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), consumerLagTimeout)
+	defer cancel()
+
+	names := make([]string, 0, len(h.consumers))
+	for name := range h.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	consumers := make([]consumerResponse, 0, len(names))
+	for _, name := range names {
+		lags, err := h.consumers[name].Lag(ctx)
+
+		resp := consumerResponse{
+			Name:       name,
+			TotalLag:   messaging.TotalLag(lags),
+			Partitions: lags,
+		}
+		if resp.Partitions == nil {
+			resp.Partitions = []messaging.PartitionLag{}
+		}
+		if err != nil {
+			// Partitions that could be queried are still reported
+			correlation.Logger(c.Request.Context()).WithError(err).WithField("consumer", name).Error("Failed to get consumer lag")
+			resp.Error = err.Error()
+		}
+
+		consumers = append(consumers, resp)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"consumers": consumers,
+	})
+}
@@ -2,45 +2,35 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"goEvents/internal/domain/service"
 	"goEvents/internal/infrastructure/messaging"
 	"net/http"
+	"sync/atomic"
 )
 
 // Handler handles HTTP requests
 type Handler struct {
 	orderService *service.OrderService
+	database     Pinger
 	producer     messaging.MessageProducer
+	consumers    map[string]messaging.MessageConsumer
+	// draining is set once shutdown started
+	draining atomic.Bool
 }
 
-// NewHandler creates a new API handler
-func NewHandler(orderService *service.OrderService, producer messaging.MessageProducer) *Handler {
+// NewHandler creates a new API handler. The database, producer and consumers are checked
+// on /readyz, the consumers, by name, are also reported on /admin/consumers.
+func NewHandler(orderService *service.OrderService, database Pinger, producer messaging.MessageProducer, consumers map[string]messaging.MessageConsumer) *Handler {
 	return &Handler{
 		orderService: orderService,
+		database:     database,
 		producer:     producer,
+		consumers:    consumers,
 	}
 }
 
-// PingHandler handles ping requests
+// PingHandler handles ping requests. It only answers, /healthz and /readyz check the application.
 func (h *Handler) PingHandler(c *gin.Context) {
-	if err := h.producer.Initialize(); err != nil {
-		logrus.WithError(err).Error("Failed to initialize producer")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to initialize producer",
-		})
-		return
-	}
-
-	err := h.producer.PublishOrder("123")
-	if err != nil {
-		logrus.WithError(err).Error("Failed to publish message")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to publish message",
-		})
-		return
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"message": "pong",
 	})
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"goEvents/internal/infrastructure/messaging"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds the dependency checks of one GET /readyz request
+const readinessTimeout = 2 * time.Second
+
+const (
+	statusUp   = "up"
+	statusDown = "down"
+)
+
+// Pinger is a dependency whose connectivity can be checked
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// dependencyStatus is the JSON representation of the check of one dependency
+type dependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Groups reports for a consumer whether it joined each of its consumer groups
+	Groups map[string]bool `json:"groups,omitempty"`
+}
+
+// Drain marks the application as shutting down, /readyz reports not ready from then on
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+// HealthzHandler handles GET /healthz, reporting that the process is alive without
+// checking any dependency
+func (h *Handler) HealthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// ReadyzHandler handles GET /readyz, checking the database, the Kafka brokers and the
+// consumer group membership of every consumer. It responds 503 when a dependency is
+// down or the application is draining, with the result of every check.
+func (h *Handler) ReadyzHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := make(map[string]dependencyStatus, 2+len(h.consumers))
+
+	// Network checks run in parallel so a slow dependency does not delay the others
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, pinger := range map[string]Pinger{"database": h.database, "kafka": h.producer} {
+		wg.Add(1)
+		go func(name string, pinger Pinger) {
+			defer wg.Done()
+			status := pingStatus(ctx, pinger)
+
+			mu.Lock()
+			defer mu.Unlock()
+			checks[name] = status
+		}(name, pinger)
+	}
+	wg.Wait()
+
+	for name, consumer := range h.consumers {
+		checks["consumer:"+name] = consumerStatus(consumer)
+	}
+
+	status, code := "ready", http.StatusOK
+	for _, check := range checks {
+		if check.Status != statusUp {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+	}
+	if h.draining.Load() {
+		status, code = "draining", http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// pingStatus checks a dependency with the request deadline
+func pingStatus(ctx context.Context, pinger Pinger) dependencyStatus {
+	if err := pinger.Ping(ctx); err != nil {
+		return dependencyStatus{Status: statusDown, Error: err.Error()}
+	}
+	return dependencyStatus{Status: statusUp}
+}
+
+// consumerStatus is up once the consumer joined all of its consumer groups
+func consumerStatus(consumer messaging.MessageConsumer) dependencyStatus {
+	groups := consumer.Groups()
+	if len(groups) == 0 {
+		return dependencyStatus{Status: statusDown, Error: "consumer is not running"}
+	}
+
+	var pending []string
+	for groupID, joined := range groups {
+		if !joined {
+			pending = append(pending, groupID)
+		}
+	}
+	if len(pending) > 0 {
+		sort.Strings(pending)
+		return dependencyStatus{
+			Status: statusDown,
+			Error:  "not joined to group " + strings.Join(pending, ", "),
+			Groups: groups,
+		}
+	}
+
+	return dependencyStatus{Status: statusUp, Groups: groups}
+}
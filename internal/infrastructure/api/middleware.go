@@ -0,0 +1,24 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"goEvents/internal/correlation"
+)
+
+// CorrelationMiddleware continues the X-Correlation-ID and W3C traceparent headers of the
+// request, or starts new ones, and stores them in the request context so the services,
+// repositories and producers called by the handlers carry them. The correlation ID is
+// returned in the X-Correlation-ID response header.
+func CorrelationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := correlation.Continue(
+			c.Request.Context(),
+			c.GetHeader(correlation.HTTPHeader),
+			c.GetHeader(correlation.TraceParentHeader),
+		)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Header(correlation.HTTPHeader, correlation.ID(ctx))
+		c.Next()
+	}
+}
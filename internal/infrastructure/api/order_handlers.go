@@ -0,0 +1,233 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"goEvents/internal/correlation"
+	"goEvents/internal/domain/model"
+	"goEvents/internal/domain/repository"
+	"goEvents/internal/domain/service"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// createOrderRequest is the payload accepted by POST /orders
+type createOrderRequest struct {
+	Description string `json:"description" binding:"required,max=255"`
+	Quantity    int    `json:"quantity" binding:"required,min=1"`
+}
+
+// updateOrderRequest is the payload accepted by PATCH /orders/:id
+type updateOrderRequest struct {
+	Description *string `json:"description" binding:"omitempty,min=1,max=255"`
+	Quantity    *int    `json:"quantity" binding:"omitempty,min=1"`
+	Status      *string `json:"status"`
+}
+
+// listOrdersQuery holds the query parameters accepted by GET /orders
+type listOrdersQuery struct {
+	Status        string    `form:"status"`
+	CreatedAfter  time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedBefore time.Time `form:"created_before" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit         int       `form:"limit" binding:"omitempty,min=1,max=1000"`
+	Offset        int       `form:"offset" binding:"omitempty,min=0"`
+}
+
+// orderResponse is the JSON representation of an order
+type orderResponse struct {
+	ID          uint      `json:"id"`
+	Description string    `json:"description"`
+	Quantity    int       `json:"quantity"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// listOrdersResponse is the JSON representation of a page of orders
+type listOrdersResponse struct {
+	Items  []orderResponse `json:"items"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+}
+
+func newOrderResponse(order *model.Order) orderResponse {
+	return orderResponse{
+		ID:          order.ID,
+		Description: order.Description,
+		Quantity:    order.Quantity,
+		Status:      string(order.Status),
+		CreatedAt:   order.CreatedAt,
+		UpdatedAt:   order.UpdatedAt,
+	}
+}
+
+// CreateOrderHandler handles POST /orders
+func (h *Handler) CreateOrderHandler(c *gin.Context) {
+	var req createOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	order, err := h.orderService.CreateOrder(c.Request.Context(), req.Description, req.Quantity)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newOrderResponse(order))
+}
+
+// ListOrdersHandler handles GET /orders
+func (h *Handler) ListOrdersHandler(c *gin.Context) {
+	var query listOrdersQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	filter := repository.OrderFilter{
+		CreatedAfter:  query.CreatedAfter,
+		CreatedBefore: query.CreatedBefore,
+		Limit:         query.Limit,
+		Offset:        query.Offset,
+	}
+
+	if query.Status != "" {
+		status, err := model.ParseOrderStatus(query.Status)
+		if err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		filter.Status = status
+	}
+
+	orders, err := h.orderService.ListOrders(c.Request.Context(), filter)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	items := make([]orderResponse, 0, len(orders))
+	for _, order := range orders {
+		items = append(items, newOrderResponse(order))
+	}
+
+	c.JSON(http.StatusOK, listOrdersResponse{
+		Items:  items,
+		Limit:  filter.PageLimit(),
+		Offset: filter.Offset,
+	})
+}
+
+// GetOrderHandler handles GET /orders/:id
+func (h *Handler) GetOrderHandler(c *gin.Context) {
+	id, ok := orderIDParam(c)
+	if !ok {
+		return
+	}
+
+	order, err := h.orderService.GetOrder(c.Request.Context(), id)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newOrderResponse(order))
+}
+
+// UpdateOrderHandler handles PATCH /orders/:id
+func (h *Handler) UpdateOrderHandler(c *gin.Context) {
+	id, ok := orderIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req updateOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	update := service.OrderUpdate{
+		Description: req.Description,
+		Quantity:    req.Quantity,
+	}
+
+	if req.Status != nil {
+		status, err := model.ParseOrderStatus(*req.Status)
+		if err != nil {
+			writeOrderError(c, err)
+			return
+		}
+		update.Status = &status
+	}
+
+	order, err := h.orderService.UpdateOrder(c.Request.Context(), id, update)
+	if err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newOrderResponse(order))
+}
+
+// DeleteOrderHandler handles DELETE /orders/:id
+func (h *Handler) DeleteOrderHandler(c *gin.Context) {
+	id, ok := orderIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.orderService.DeleteOrder(c.Request.Context(), id); err != nil {
+		writeOrderError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// orderIDParam parses the :id path parameter, writing a 400 response if it is invalid
+func orderIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "order id must be a positive integer",
+		})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// writeOrderError maps domain errors to HTTP responses
+func writeOrderError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, model.ErrOrderNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, model.ErrInvalidTransition), errors.Is(err, model.ErrConcurrentUpdate):
+		status = http.StatusConflict
+	case errors.Is(err, model.ErrInvalidStatus):
+		status = http.StatusBadRequest
+	}
+
+	if status == http.StatusInternalServerError {
+		correlation.Logger(c.Request.Context()).WithError(err).Error("Failed to handle order request")
+		c.JSON(status, gin.H{
+			"error": "internal server error",
+		})
+		return
+	}
+
+	c.JSON(status, gin.H{
+		"error": err.Error(),
+	})
+}
@@ -2,15 +2,44 @@ package api
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"goEvents/internal/telemetry"
 )
 
 // SetupRouter configures the HTTP router
 func SetupRouter(handler *Handler) *gin.Engine {
 	router := gin.Default()
 
+	// The server span is started first so the correlation ID follows its trace
+	router.Use(otelgin.Middleware(telemetry.DefaultServiceName))
+	router.Use(CorrelationMiddleware())
+	router.Use(MetricsMiddleware())
+
+	// Prometheus metrics of the HTTP server, Kafka clients and database pools
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Liveness and readiness probes
+	router.GET("/healthz", handler.HealthzHandler)
+	router.GET("/readyz", handler.ReadyzHandler)
+
 	// Register routes
 	router.GET("/ping", handler.PingHandler)
 	router.GET("/hello", handler.HelloHandler)
 
+	orders := router.Group("/orders")
+	{
+		orders.POST("", handler.CreateOrderHandler)
+		orders.GET("", handler.ListOrdersHandler)
+		orders.GET("/:id", handler.GetOrderHandler)
+		orders.PATCH("/:id", handler.UpdateOrderHandler)
+		orders.DELETE("/:id", handler.DeleteOrderHandler)
+	}
+
+	admin := router.Group("/admin")
+	{
+		admin.GET("/consumers", handler.ListConsumersHandler)
+	}
+
 	return router
 }
\ No newline at end of file
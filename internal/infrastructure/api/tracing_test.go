@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"goEvents/internal/correlation"
+	"goEvents/internal/domain/model"
+	"goEvents/internal/domain/repository"
+	"goEvents/internal/domain/service"
+	"goEvents/internal/infrastructure/messaging"
+	"goEvents/internal/infrastructure/messaging/event"
+	"goEvents/internal/telemetry"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tracingTestOutbox keeps orders and their outbox messages in memory, capturing the
+// trace context of the request like the persistence repositories do
+type tracingTestOutbox struct {
+	repository.OrderRepository
+
+	mu       sync.Mutex
+	messages []*model.OutboxMessage
+	sent     map[uint64]bool
+}
+
+func (o *tracingTestOutbox) SaveOrder(ctx context.Context, order *model.Order) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	order.ID = uint(len(o.messages) + 1)
+	evt := event.NewOrderEvent(event.TypeOrderCreated, order)
+	payload, err := event.Encode(evt)
+	if err != nil {
+		return err
+	}
+
+	message := &model.OutboxMessage{
+		ID:            uint64(order.ID),
+		AggregateID:   order.ID,
+		EventID:       evt.EventID,
+		EventType:     evt.EventType,
+		Payload:       payload,
+		CorrelationID: correlation.ID(ctx),
+	}
+	if tp, ok := correlation.TraceParentFromContext(ctx); ok {
+		message.TraceParent = tp.String()
+	}
+	o.messages = append(o.messages, message)
+	return nil
+}
+
+func (o *tracingTestOutbox) FetchPendingOutbox(_ context.Context, limit int) ([]*model.OutboxMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var pending []*model.OutboxMessage
+	for _, message := range o.messages {
+		if !o.sent[message.ID] && len(pending) < limit {
+			pending = append(pending, message)
+		}
+	}
+	return pending, nil
+}
+
+func (o *tracingTestOutbox) MarkOutboxSent(_ context.Context, ids []uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, id := range ids {
+		o.sent[id] = true
+	}
+	return nil
+}
+
+func (o *tracingTestOutbox) MarkOutboxFailed(context.Context, uint64, string) error {
+	return nil
+}
+
+func (o *tracingTestOutbox) MarkOutboxDead(context.Context, uint64, string) error {
+	return nil
+}
+
+// sentCount returns how many outbox messages were published
+func (o *tracingTestOutbox) sentCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.sent)
+}
+
+// TestCreateOrderTraceReachesPublish creates an order over HTTP, relays its outbox message
+// and checks that the server, service and publish spans form one chain of the same trace
+func TestCreateOrderTraceReachesPublish(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := telemetry.NewTracerProvider("test", sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		_ = provider.Shutdown(context.Background())
+	}()
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	config := messaging.DefaultProducerConfig()
+	config.BootstrapServers = cluster.ListenAddrs()[0]
+	producer := messaging.NewFranzKafkaProducer(&config)
+	defer producer.Shutdown(context.Background())
+
+	outbox := &tracingTestOutbox{sent: make(map[uint64]bool)}
+	router := SetupRouter(NewHandler(service.NewOrderService(outbox), nil, producer, nil))
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"description":"traced","quantity":2}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /orders = %d %s, want 201", rec.Code, rec.Body.String())
+	}
+
+	// The relay publishes from its own goroutine, long after the request ended
+	ctx, cancel := context.WithCancel(context.Background())
+	relay := messaging.NewOutboxRelay(outbox, producer, messaging.OutboxRelayConfig{PollInterval: 10 * time.Millisecond})
+	relay.Start(ctx)
+	deadline := time.Now().Add(30 * time.Second)
+	for outbox.sentCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the outbox message to be published")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	relay.Wait()
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		if span.SpanKind == trace.SpanKindServer {
+			spans["server"] = span
+		} else {
+			spans[span.Name] = span
+		}
+	}
+
+	// Each span must be a child of the one before it
+	chain := []string{"server", "OrderService.CreateOrder", "orders publish"}
+	for i, name := range chain {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no %s span recorded", name)
+		}
+		if i == 0 {
+			continue
+		}
+
+		parent := spans[chain[i-1]]
+		if span.SpanContext.TraceID() != parent.SpanContext.TraceID() {
+			t.Errorf("%s span is in trace %s, want the trace of the request %s", name, span.SpanContext.TraceID(), parent.SpanContext.TraceID())
+		}
+		if span.Parent.SpanID() != parent.SpanContext.SpanID() {
+			t.Errorf("%s span parent = %s, want the %s span %s", name, span.Parent.SpanID(), chain[i-1], parent.SpanContext.SpanID())
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package messaging
+
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBackpressureProbe is how long fetching stays paused before probing an idle handler
+	defaultBackpressureProbe = time.Second
+	// latencySmoothing is the weight of the newest sample in the average handler latency
+	latencySmoothing = 0.2
+)
+
+// BackpressureConfig pauses fetching on all assigned partitions when the handler falls
+// behind, e.g. because the database is slow, and resumes once it caught up. Fetching
+// resumes when both values dropped below half their threshold.
+type BackpressureConfig struct {
+	// MaxInFlight pauses fetching once this many records are queued or being handled.
+	// Only more than one record can be in flight with Workers. Zero disables the limit.
+	MaxInFlight int
+	// MaxLatency pauses fetching once the average handler latency exceeds it.
+	// Zero disables the limit.
+	MaxLatency time.Duration
+	// ProbeInterval is how long fetching stays paused while nothing is in flight before it
+	// resumes to measure the handler again, defaults to one second
+	ProbeInterval time.Duration
+}
+
+// enabled reports whether any backpressure threshold is configured
+func (c BackpressureConfig) enabled() bool {
+	return c.MaxInFlight > 0 || c.MaxLatency > 0
+}
+
+// flowController tracks in-flight records and handler latency of one consumer client
+// and pauses or resumes fetching through the client specific callbacks
+type flowController struct {
+	mu       sync.Mutex
+	config   BackpressureConfig
+	client   string
+	group    string
+	pause    func()
+	resume   func()
+	inFlight int
+	latency  time.Duration
+	paused   bool
+	pausedAt time.Time
+	probe    *time.Timer
+	closed   bool
+}
+
+// newFlowController creates a controller, or nil when backpressure is not configured
+func newFlowController(config *ConsumerConfig, client, group string, pause, resume func()) *flowController {
+	if !config.Backpressure.enabled() {
+		return nil
+	}
+
+	bp := config.Backpressure
+	if bp.ProbeInterval <= 0 {
+		bp.ProbeInterval = defaultBackpressureProbe
+	}
+
+	return &flowController{
+		config: bp,
+		client: client,
+		group:  group,
+		pause:  pause,
+		resume: resume,
+	}
+}
+
+// started records that n records were queued or started
+func (f *flowController) started(n int) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inFlight += n
+	f.update()
+}
+
+// finished records that n records were handled in one call taking the given time
+func (f *flowController) finished(n int, took time.Duration) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inFlight -= n
+	if f.latency == 0 {
+		f.latency = took
+	} else {
+		f.latency += time.Duration(latencySmoothing * float64(took-f.latency))
+	}
+	f.update()
+}
+
+// dropped records that n started records were abandoned without being handled
+func (f *flowController) dropped(n int) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inFlight -= n
+	f.update()
+}
+
+// reapply pauses fetching again after partitions were reassigned while paused
+func (f *flowController) reapply() {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.paused {
+		f.pause()
+	}
+}
+
+// close stops a pending probe and further changes, fetching is not resumed as the client is closing
+func (f *flowController) close() {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.probe != nil {
+		f.probe.Stop()
+	}
+	consumerPaused.WithLabelValues(f.client, f.group).Set(0)
+}
+
+// update pauses or resumes fetching according to the thresholds, the lock must be held
+func (f *flowController) update() {
+	if f.closed {
+		return
+	}
+
+	if !f.paused {
+		if reason := f.overloaded(); reason != "" {
+			f.pauseLocked(reason)
+		}
+		return
+	}
+
+	if f.recovered() {
+		f.resumeLocked()
+	}
+}
+
+// overloaded returns why the consumer should pause, or an empty string
+func (f *flowController) overloaded() string {
+	switch {
+	case f.config.MaxInFlight > 0 && f.inFlight >= f.config.MaxInFlight:
+		return "in_flight"
+	case f.config.MaxLatency > 0 && f.latency >= f.config.MaxLatency:
+		return "latency"
+	default:
+		return ""
+	}
+}
+
+// recovered reports whether both values dropped below half their threshold
+func (f *flowController) recovered() bool {
+	if f.config.MaxInFlight > 0 && f.inFlight > f.config.MaxInFlight/2 {
+		return false
+	}
+	if f.config.MaxLatency > 0 && f.latency > f.config.MaxLatency/2 {
+		return false
+	}
+	return true
+}
+
+// pauseLocked pauses fetching and schedules a probe, the lock must be held
+func (f *flowController) pauseLocked(reason string) {
+	f.paused = true
+	f.pausedAt = time.Now()
+	f.pause()
+
+	consumerPausesTotal.WithLabelValues(f.client, f.group, reason).Inc()
+	consumerPaused.WithLabelValues(f.client, f.group).Set(1)
+
+	logrus.WithFields(logrus.Fields{
+		"client":     f.client,
+		"group_id":   f.group,
+		"reason":     reason,
+		"in_flight":  f.inFlight,
+		"latency_ms": f.latency.Milliseconds(),
+	}).Warn("Consumer falling behind, pausing partitions")
+
+	f.probe = time.AfterFunc(f.config.ProbeInterval, f.probeIdle)
+}
+
+// resumeLocked resumes fetching, the lock must be held
+func (f *flowController) resumeLocked() {
+	f.paused = false
+	if f.probe != nil {
+		f.probe.Stop()
+	}
+	f.resume()
+
+	consumerPaused.WithLabelValues(f.client, f.group).Set(0)
+
+	logrus.WithFields(logrus.Fields{
+		"client":     f.client,
+		"group_id":   f.group,
+		"in_flight":  f.inFlight,
+		"latency_ms": f.latency.Milliseconds(),
+		"paused_ms":  time.Since(f.pausedAt).Milliseconds(),
+	}).Info("Consumer caught up, resuming partitions")
+}
+
+// probeIdle resumes fetching once nothing is in flight anymore. The latency average
+// cannot improve without new records, so it restarts from the resume threshold and
+// the next records decide whether to pause again.
+func (f *flowController) probeIdle() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.paused || f.closed {
+		return
+	}
+
+	if f.inFlight > 0 {
+		f.probe = time.AfterFunc(f.config.ProbeInterval, f.probeIdle)
+		return
+	}
+
+	f.latency = f.config.MaxLatency / 2
+	f.resumeLocked()
+}
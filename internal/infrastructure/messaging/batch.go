@@ -0,0 +1,115 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"goEvents/internal/correlation"
+	"time"
+)
+
+// defaultBatchTimeout is how long a batch waits to fill up when BatchTimeout is not set
+const defaultBatchTimeout = 100 * time.Millisecond
+
+// BatchHandler is implemented by handlers that can process several messages in one call,
+// e.g. to persist them with a single multi-row insert. HandleBatch should apply all
+// messages or none of them: when it fails, the messages are passed to Handle one by one
+// so a single bad record is retried or dead-lettered on its own. A handler that applied
+// the leading messages before failing returns a *PartialBatchError instead, and only
+// the messages after them are handled again.
+type BatchHandler interface {
+	MessageHandler
+	HandleBatch(ctx context.Context, msgs []*Message) error
+}
+
+// PartialBatchError is returned by a BatchHandler that applied the first Handled
+// messages of a batch before failing
+type PartialBatchError struct {
+	Handled int
+	Err     error
+}
+
+func (e *PartialBatchError) Error() string {
+	return fmt.Sprintf("batch failed after %d messages: %v", e.Handled, e.Err)
+}
+
+func (e *PartialBatchError) Unwrap() error {
+	return e.Err
+}
+
+// batchHandled returns how many leading messages of a failed batch were applied
+func batchHandled(err error, size int) int {
+	var partial *PartialBatchError
+	if !errors.As(err, &partial) || partial.Handled < 0 {
+		return 0
+	}
+	return min(partial.Handled, size)
+}
+
+// batchTimeout returns the configured batch timeout or its default
+func (c *ConsumerConfig) batchTimeout() time.Duration {
+	if c.BatchTimeout <= 0 {
+		return defaultBatchTimeout
+	}
+	return c.BatchTimeout
+}
+
+// batching reports whether the consumer should gather messages into batches
+func (p *messageProcessor) batching() bool {
+	return p.batchHandler != nil
+}
+
+// handleBatch passes a batch to the batch handler, falling back to handling its
+// messages one by one if the batch fails. It returns how many leading messages of
+// the batch were handled and can be committed, with the error that stopped it.
+func (p *messageProcessor) handleBatch(ctx context.Context, msgs []*Message) (int, error) {
+	// Records from retry topics are held until their due time
+	for _, msg := range msgs {
+		if err := waitUntilDue(ctx, msg); err != nil {
+			return 0, err
+		}
+	}
+
+	startTime := time.Now()
+
+	// A batch mixes records of several requests, so it gets its own trace and
+	// correlation ID and logs the ones of its records
+	batchCtx, span := startBatchProcessSpan(ctx, msgs)
+	batchCtx = correlation.Continue(batchCtx, "", "")
+
+	err := p.batchHandler.HandleBatch(batchCtx, msgs)
+	endSpan(span, err)
+	observeBatch(p.client, msgs[0].Topic, len(msgs), time.Since(startTime), err)
+	if err == nil {
+		correlation.Logger(batchCtx).WithFields(logrus.Fields{
+			"topic":              msgs[0].Topic,
+			"count":              len(msgs),
+			"correlation_ids":    batchCorrelationIDs(msgs),
+			"processing_time_ms": time.Since(startTime).Milliseconds(),
+		}).Info("Batch processed")
+		return len(msgs), nil
+	}
+
+	// Messages a partial batch already applied are not handled again
+	handled := batchHandled(err, len(msgs))
+
+	correlation.Logger(batchCtx).WithError(err).WithFields(logrus.Fields{
+		"topic":           msgs[0].Topic,
+		"count":           len(msgs),
+		"handled":         handled,
+		"correlation_ids": batchCorrelationIDs(msgs),
+	}).Warn("Batch failed, handling the remaining messages one by one")
+
+	// Each message is handled with its own correlation ID
+	for i, msg := range msgs[handled:] {
+		if err := p.handle(ctx, msg); err != nil {
+			// Auto mode moves past failed records like the single message path does
+			if p.commitMode != CommitModeAuto || ctx.Err() != nil {
+				return handled + i, err
+			}
+		}
+	}
+
+	return len(msgs), nil
+}
@@ -0,0 +1,131 @@
+package messaging
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"goEvents/internal/correlation"
+	"time"
+)
+
+// CommitMode controls when consumed offsets are committed
+type CommitMode string
+
+const (
+	// CommitModeAuto lets the client commit the offsets of processed records in the
+	// background. A record that fails without a retry or dead-letter topic is skipped,
+	// and a crash may redeliver records processed since the last commit.
+	CommitModeAuto CommitMode = "auto"
+	// CommitModeAfterSuccess commits a record's offset synchronously once it was
+	// handled, either successfully or by forwarding it to a retry or dead-letter topic.
+	// A record that cannot be handled is retried in place and never skipped.
+	CommitModeAfterSuccess CommitMode = "after-success"
+	// CommitModeManualBatch has the same guarantees as CommitModeAfterSuccess but
+	// commits handled offsets every CommitBatchSize records or CommitInterval.
+	CommitModeManualBatch CommitMode = "manual-batch"
+)
+
+const (
+	// defaultCommitBatchSize is used by CommitModeManualBatch when CommitBatchSize is not set
+	defaultCommitBatchSize = 100
+	// defaultCommitInterval is used by CommitModeManualBatch when CommitInterval is not set
+	defaultCommitInterval = 5 * time.Second
+	// commitTimeout bounds the final commit made while a consumer shuts down
+	commitTimeout = 10 * time.Second
+	// maxRetryBackoff caps the wait between in-place attempts of a record that cannot be handled
+	maxRetryBackoff = 30 * time.Second
+)
+
+// commitMode returns the configured commit mode, defaulting to auto
+func (c *ConsumerConfig) commitMode() CommitMode {
+	if c.CommitMode == "" {
+		return CommitModeAuto
+	}
+	return c.CommitMode
+}
+
+// commitContext returns the context for a commit, which must still reach the broker
+// when it is made while shutting down
+func commitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() != nil {
+		return context.WithTimeout(context.Background(), commitTimeout)
+	}
+	return ctx, func() {}
+}
+
+// commitBatch decides when handled offsets should be committed in manual batch mode
+type commitBatch struct {
+	size       int
+	interval   time.Duration
+	pending    int
+	lastCommit time.Time
+}
+
+// newCommitBatch creates a batch using the configured size and interval or their defaults
+func newCommitBatch(config *ConsumerConfig) *commitBatch {
+	b := &commitBatch{
+		size:       config.CommitBatchSize,
+		interval:   config.CommitInterval,
+		lastCommit: time.Now(),
+	}
+	if b.size <= 0 {
+		b.size = defaultCommitBatchSize
+	}
+	if b.interval <= 0 {
+		b.interval = defaultCommitInterval
+	}
+	return b
+}
+
+// add records n handled offsets and reports whether the batch should be committed now
+func (b *commitBatch) add(n int) bool {
+	b.pending += n
+	return b.pending >= b.size || b.due()
+}
+
+// due reports whether uncommitted offsets have waited longer than the interval
+func (b *commitBatch) due() bool {
+	return b.pending > 0 && time.Since(b.lastCommit) >= b.interval
+}
+
+// reset marks the batch as committed
+func (b *commitBatch) reset() {
+	b.pending = 0
+	b.lastCommit = time.Now()
+}
+
+// handle processes a message according to the commit mode. In auto mode the
+// message is attempted once. Otherwise it is retried in place with backoff until
+// it is handled, so its offset is never committed before that; an error is then
+// only returned when the context is canceled.
+func (p *messageProcessor) handle(ctx context.Context, msg *Message) (err error) {
+	ctx, span := startProcessSpan(ctx, msg)
+	defer func() { endSpan(span, err) }()
+
+	// The handler and its repository calls carry the correlation ID of the producer
+	ctx = messageContext(ctx, msg)
+
+	err = p.process(ctx, msg)
+	if err == nil || p.commitMode == CommitModeAuto {
+		return err
+	}
+
+	backoff := 100 * time.Millisecond
+	for err != nil {
+		correlation.Logger(ctx).WithError(err).WithFields(logrus.Fields{
+			"topic":     msg.Topic,
+			"partition": msg.Partition,
+			"offset":    msg.Offset,
+			"backoff":   backoff.String(),
+		}).Warn("Message could not be handled, retrying before committing")
+
+		sleepContext(ctx, backoff)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		backoff = nextBackoff(backoff)
+
+		err = p.process(ctx, msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,543 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	commitTestTopic   = "orders"
+	commitTestRecords = 30
+	commitTestFailAt  = 12
+)
+
+// errHandlerDown is returned by the test handler for the record it cannot handle yet
+var errHandlerDown = errors.New("handler down")
+
+// recordingHandler records every attempt and fails the record at commitTestFailAt while failing is set
+type recordingHandler struct {
+	mu       sync.Mutex
+	failing  bool
+	attempts map[int64]int
+	handled  map[int64]bool
+}
+
+func newRecordingHandler() *recordingHandler {
+	return &recordingHandler{
+		failing:  true,
+		attempts: make(map[int64]int),
+		handled:  make(map[int64]bool),
+	}
+}
+
+func (h *recordingHandler) Handle(_ context.Context, msg *Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.attempts[msg.Offset]++
+	if h.failing && msg.Offset == commitTestFailAt {
+		return errHandlerDown
+	}
+	h.handled[msg.Offset] = true
+	return nil
+}
+
+// recover lets the failing record be handled from now on
+func (h *recordingHandler) recover() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failing = false
+}
+
+// attemptsOf returns how often the record at offset was passed to the handler
+func (h *recordingHandler) attemptsOf(offset int64) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.attempts[offset]
+}
+
+// lowest returns the lowest offset not in seen, or the record count if all are
+func (h *recordingHandler) lowest(seen func(offset int64) bool) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for offset := int64(0); offset < commitTestRecords; offset++ {
+		if !seen(offset) {
+			return offset
+		}
+	}
+	return commitTestRecords
+}
+
+// lowestUnhandled is the offset no commit may pass in after-success and manual-batch mode
+func (h *recordingHandler) lowestUnhandled() int64 {
+	return h.lowest(func(offset int64) bool { return h.handled[offset] })
+}
+
+// lowestUnattempted is the offset no commit may pass in auto mode, which skips failed records
+func (h *recordingHandler) lowestUnattempted() int64 {
+	return h.lowest(func(offset int64) bool { return h.attempts[offset] > 0 })
+}
+
+// commitTestBroker serves a single partition holding commitTestRecords records to the
+// consumer of one client and keeps the offset its group committed
+type commitTestBroker interface {
+	// consume starts a consumer resuming at the committed offset and returns a function
+	// waiting for it to stop once ctx is canceled
+	consume(ctx context.Context, handler MessageHandler, config *ConsumerConfig) (wait func())
+	// committed returns the committed offset, -1 if there is none
+	committed(ctx context.Context) (int64, error)
+}
+
+// commitTestClients creates the broker each client is tested against. Sarama cannot talk
+// to the in-memory cluster, so its group handler is driven through a fake session instead.
+var commitTestClients = map[string]func(t *testing.T) commitTestBroker{
+	"franz": func(t *testing.T) commitTestBroker {
+		return newKfakeBroker(t, func(handler MessageHandler, config *ConsumerConfig) MessageConsumer {
+			return NewFranzKafkaConsumer(handler, config)
+		})
+	},
+	"confluent": func(t *testing.T) commitTestBroker {
+		return newKfakeBroker(t, func(handler MessageHandler, config *ConsumerConfig) MessageConsumer {
+			return NewConfluentKafkaConsumer(handler, config)
+		})
+	},
+	"sarama": func(t *testing.T) commitTestBroker {
+		return &saramaTestGroup{committedOffset: -1}
+	},
+}
+
+// TestCommitModesNeverPassUnhandledRecords makes the handler fail partway through, stops
+// the consumer while the record is being retried and restarts it with a working handler.
+// A monitor checks throughout that no committed offset passes a record the commit mode
+// must not skip: an unhandled record, or in auto mode a record never passed to the handler.
+func TestCommitModesNeverPassUnhandledRecords(t *testing.T) {
+	for client, newBroker := range commitTestClients {
+		for _, mode := range []CommitMode{CommitModeAuto, CommitModeAfterSuccess, CommitModeManualBatch} {
+			for _, workers := range []int{1, 4} {
+				t.Run(fmt.Sprintf("%s/%s/workers=%d", client, mode, workers), func(t *testing.T) {
+					t.Parallel()
+					testCommitMode(t, newBroker(t), mode, workers)
+				})
+			}
+		}
+	}
+}
+
+func testCommitMode(t *testing.T, broker commitTestBroker, mode CommitMode, workers int) {
+	config := &ConsumerConfig{
+		GroupID:         "commit-test",
+		Topics:          []string{commitTestTopic},
+		AutoOffsetReset: "earliest",
+		CommitMode:      mode,
+		CommitBatchSize: 3,
+		CommitInterval:  50 * time.Millisecond,
+		Workers:         workers,
+	}
+
+	handler := newRecordingHandler()
+	bound := handler.lowestUnhandled
+	if mode == CommitModeAuto {
+		bound = handler.lowestUnattempted
+	}
+
+	// The committed offset is read before the bound, which only grows, so a violation
+	// may be missed but never reported falsely
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	monitorDone := make(chan struct{})
+	go func() {
+		defer close(monitorDone)
+		for monitorCtx.Err() == nil {
+			committed, err := broker.committed(monitorCtx)
+			if err == nil {
+				if limit := bound(); committed > limit {
+					t.Errorf("committed offset %d passes record %d", committed, limit)
+					return
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	defer func() {
+		stopMonitor()
+		<-monitorDone
+	}()
+
+	// First run: the record at commitTestFailAt cannot be handled
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := broker.consume(ctx, handler, config)
+
+	waitFor(t, "the failing record to be attempted", func() bool {
+		if mode == CommitModeAuto {
+			return handler.lowestUnattempted() == commitTestRecords
+		}
+		return handler.attemptsOf(commitTestFailAt) >= 3
+	})
+
+	cancel()
+	wait()
+
+	committed, err := broker.committed(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode == CommitModeAuto {
+		// Auto mode attempts a failed record once and moves past it
+		if committed != commitTestRecords {
+			t.Fatalf("committed offset after the first run is %d, want %d", committed, commitTestRecords)
+		}
+	} else if committed != commitTestFailAt {
+		t.Fatalf("committed offset after the first run is %d, want the failing record %d", committed, commitTestFailAt)
+	}
+
+	// Second run: the restarted consumer resumes at the committed offset
+	handler.recover()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	wait = broker.consume(ctx, handler, config)
+
+	waitFor(t, "every record to be committed", func() bool {
+		committed, err := broker.committed(context.Background())
+		return err == nil && committed == commitTestRecords
+	})
+
+	cancel()
+	wait()
+
+	if mode == CommitModeAuto {
+		if attempts := handler.attemptsOf(commitTestFailAt); attempts != 1 {
+			t.Errorf("auto mode attempted the failed record %d times, want 1", attempts)
+		}
+	} else if unhandled := handler.lowestUnhandled(); unhandled != commitTestRecords {
+		t.Errorf("record %d was never handled", unhandled)
+	}
+}
+
+// gatedHandler holds every record until the gate is opened and counts the handled ones
+type gatedHandler struct {
+	gate    chan struct{}
+	mu      sync.Mutex
+	handled map[int64]int
+}
+
+func (h *gatedHandler) Handle(ctx context.Context, msg *Message) error {
+	select {
+	case <-h.gate:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handled[msg.Offset]++
+	return nil
+}
+
+// TestSaramaRevokeHandlesQueuedRecords ends a session while records wait on the worker
+// lanes, like a rebalance does, and checks that they are handled and committed by Cleanup
+// instead of being left for the next owner
+func TestSaramaRevokeHandlesQueuedRecords(t *testing.T) {
+	for _, mode := range []CommitMode{CommitModeAuto, CommitModeAfterSuccess, CommitModeManualBatch} {
+		t.Run(string(mode), func(t *testing.T) {
+			config := &ConsumerConfig{
+				GroupID:         "commit-test",
+				Topics:          []string{commitTestTopic},
+				CommitMode:      mode,
+				CommitBatchSize: 3,
+				CommitInterval:  time.Hour,
+				Workers:         4,
+			}
+			handler := &gatedHandler{gate: make(chan struct{}), handled: make(map[int64]int)}
+			processor := newMessageProcessor(handler, config, "sarama", nil)
+			defer processor.close()
+
+			groupHandler := &saramaConsumerGroupHandler{
+				ctx:        context.Background(),
+				processor:  processor,
+				config:     config,
+				groupID:    config.GroupID,
+				assignment: newAssignedPartitions(),
+			}
+
+			group := &saramaTestGroup{committedOffset: -1}
+			sessionCtx, revoke := context.WithCancel(context.Background())
+			session := &saramaTestSession{ctx: sessionCtx, group: group}
+			claim := &saramaTestClaim{messages: make(chan *sarama.ConsumerMessage, commitTestRecords)}
+			for offset := int64(0); offset < commitTestRecords; offset++ {
+				claim.messages <- &sarama.ConsumerMessage{Topic: commitTestTopic, Offset: offset, Key: commitTestKey(int(offset))}
+			}
+
+			if err := groupHandler.Setup(session); err != nil {
+				t.Fatal(err)
+			}
+			claimed := make(chan struct{})
+			go func() {
+				defer close(claimed)
+				_ = groupHandler.ConsumeClaim(session, claim)
+			}()
+
+			// Every record is queued while the handler holds the first one of each lane
+			waitFor(t, "the records to be queued", func() bool {
+				groupHandler.dispatcher.mu.Lock()
+				defer groupHandler.dispatcher.mu.Unlock()
+				return groupHandler.dispatcher.queued == commitTestRecords
+			})
+
+			revoke()
+			<-claimed
+			close(handler.gate)
+			if err := groupHandler.Cleanup(session); err != nil {
+				t.Fatal(err)
+			}
+			// Sarama commits the marked offsets once the session is released, auto mode relies on it
+			if mode == CommitModeAuto {
+				session.Commit()
+			}
+
+			if committed, _ := group.committed(context.Background()); committed != commitTestRecords {
+				t.Errorf("committed offset = %d, want %d", committed, commitTestRecords)
+			}
+			for offset := int64(0); offset < commitTestRecords; offset++ {
+				if got := handler.handled[offset]; got != 1 {
+					t.Errorf("offset %d handled %d times, want once", offset, got)
+				}
+			}
+		})
+	}
+}
+
+// kfakeBroker runs a client's consumer against an in-memory cluster
+type kfakeBroker struct {
+	bootstrapServers string
+	admin            *kgo.Client
+	newConsumer      func(handler MessageHandler, config *ConsumerConfig) MessageConsumer
+}
+
+// newKfakeBroker starts a cluster holding the test records, closed when the test ends
+func newKfakeBroker(t *testing.T, newConsumer func(handler MessageHandler, config *ConsumerConfig) MessageConsumer) *kfakeBroker {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, commitTestTopic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	b := &kfakeBroker{
+		bootstrapServers: cluster.ListenAddrs()[0],
+		newConsumer:      newConsumer,
+	}
+
+	b.admin, err = kgo.NewClient(kgo.SeedBrokers(b.bootstrapServers))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.admin.Close)
+
+	// Keys spread the records of the single partition over the worker lanes, so they
+	// complete out of order when there are several workers
+	for i := 0; i < commitTestRecords; i++ {
+		record := &kgo.Record{Topic: commitTestTopic, Key: commitTestKey(i), Value: []byte(strconv.Itoa(i))}
+		if err := b.admin.ProduceSync(context.Background(), record).FirstErr(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return b
+}
+
+func (b *kfakeBroker) consume(ctx context.Context, handler MessageHandler, config *ConsumerConfig) func() {
+	config.BootstrapServers = b.bootstrapServers
+	consumer := b.newConsumer(handler, config)
+	consumer.Start(ctx)
+	return consumer.Wait
+}
+
+func (b *kfakeBroker) committed(ctx context.Context) (int64, error) {
+	return committedOffset(ctx, b.admin, "commit-test")
+}
+
+// saramaTestGroup runs sessions of the Sarama group handler like sarama.ConsumerGroup does,
+// committing marked offsets in the background in auto mode and when a session ends
+type saramaTestGroup struct {
+	mu              sync.Mutex
+	committedOffset int64
+}
+
+func (g *saramaTestGroup) consume(ctx context.Context, handler MessageHandler, config *ConsumerConfig) func() {
+	processor := newMessageProcessor(handler, config, "sarama", nil)
+	groupHandler := &saramaConsumerGroupHandler{
+		ctx:        ctx,
+		processor:  processor,
+		config:     config,
+		groupID:    config.GroupID,
+		assignment: newAssignedPartitions(),
+	}
+
+	g.mu.Lock()
+	next := g.committedOffset
+	g.mu.Unlock()
+	if next < 0 {
+		next = 0
+	}
+
+	session := &saramaTestSession{ctx: ctx, group: g, marked: next}
+	claim := &saramaTestClaim{messages: make(chan *sarama.ConsumerMessage, commitTestRecords)}
+	for offset := next; offset < commitTestRecords; offset++ {
+		claim.messages <- &sarama.ConsumerMessage{
+			Topic:  commitTestTopic,
+			Offset: offset,
+			Key:    commitTestKey(int(offset)),
+			Value:  []byte(strconv.FormatInt(offset, 10)),
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer processor.close()
+
+		if err := groupHandler.Setup(session); err != nil {
+			panic(err)
+		}
+
+		autoCommit := time.NewTicker(10 * time.Millisecond)
+		defer autoCommit.Stop()
+
+		claimed := make(chan struct{})
+		go func() {
+			defer close(claimed)
+			_ = groupHandler.ConsumeClaim(session, claim)
+		}()
+
+		for running := true; running; {
+			select {
+			case <-autoCommit.C:
+				if config.commitMode() == CommitModeAuto {
+					session.Commit()
+				}
+			case <-claimed:
+				running = false
+			}
+		}
+
+		_ = groupHandler.Cleanup(session)
+		session.Commit()
+	}()
+
+	return func() { <-done }
+}
+
+func (g *saramaTestGroup) committed(context.Context) (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.committedOffset, nil
+}
+
+// saramaTestSession is the session of a saramaTestGroup, marking offsets only forward
+type saramaTestSession struct {
+	ctx    context.Context
+	group  *saramaTestGroup
+	mu     sync.Mutex
+	marked int64
+}
+
+func (s *saramaTestSession) Claims() map[string][]int32 {
+	return map[string][]int32{commitTestTopic: {0}}
+}
+
+func (s *saramaTestSession) MemberID() string         { return "member" }
+func (s *saramaTestSession) GenerationID() int32      { return 1 }
+func (s *saramaTestSession) Context() context.Context { return s.ctx }
+
+func (s *saramaTestSession) MarkOffset(_ string, _ int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset > s.marked {
+		s.marked = offset
+	}
+}
+
+func (s *saramaTestSession) ResetOffset(_ string, _ int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = offset
+}
+
+func (s *saramaTestSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *saramaTestSession) Commit() {
+	s.mu.Lock()
+	marked := s.marked
+	s.mu.Unlock()
+
+	s.group.mu.Lock()
+	defer s.group.mu.Unlock()
+	s.group.committedOffset = marked
+}
+
+// saramaTestClaim hands the queued messages to the group handler
+type saramaTestClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *saramaTestClaim) Topic() string                            { return commitTestTopic }
+func (c *saramaTestClaim) Partition() int32                         { return 0 }
+func (c *saramaTestClaim) InitialOffset() int64                     { return 0 }
+func (c *saramaTestClaim) HighWaterMarkOffset() int64               { return commitTestRecords }
+func (c *saramaTestClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// commitTestKey spreads the test records over five keys
+func commitTestKey(i int) []byte {
+	return []byte("key-" + strconv.Itoa(i%5))
+}
+
+// committedOffset fetches the offset the group committed for the test partition, -1 if none
+func committedOffset(ctx context.Context, client *kgo.Client, groupID string) (int64, error) {
+	req := kmsg.NewPtrOffsetFetchRequest()
+	req.Group = groupID
+	topic := kmsg.NewOffsetFetchRequestTopic()
+	topic.Topic = commitTestTopic
+	topic.Partitions = []int32{0}
+	req.Topics = append(req.Topics, topic)
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	if err := kerr.ErrorForCode(resp.ErrorCode); err != nil {
+		return 0, err
+	}
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+				return 0, err
+			}
+			return p.Offset, nil
+		}
+	}
+	return -1, nil
+}
+
+// waitFor polls condition until it holds, failing the test after a timeout
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -2,70 +2,141 @@ package messaging
 
 import (
 	"context"
+	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"goEvents/internal/domain/service"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// confluentLagTimeout bounds the broker queries of a lag request without a context deadline
+const confluentLagTimeout = 5 * time.Second
+
 // ConfluentKafkaConsumer implements the MessageConsumer interface using Confluent's Kafka client
 type ConfluentKafkaConsumer struct {
-	orderService *service.OrderService
-	config       *ConsumerConfig
-	wg           sync.WaitGroup
+	handler MessageHandler
+	config  *ConsumerConfig
+	wg      sync.WaitGroup
+	lag     lagRegistry
+	groups  groupMembership
 }
 
-// NewConfluentKafkaConsumer creates a new Kafka consumer with the given order service
-func NewConfluentKafkaConsumer(orderService *service.OrderService, config *ConsumerConfig) *ConfluentKafkaConsumer {
+// NewConfluentKafkaConsumer creates a new Kafka consumer passing messages to the given handler
+func NewConfluentKafkaConsumer(handler MessageHandler, config *ConsumerConfig) *ConfluentKafkaConsumer {
 	if config == nil {
 		logrus.Fatal("Kafka configuration must be provided")
 	}
 
 	return &ConfluentKafkaConsumer{
-		orderService: orderService,
-		config:       config,
+		handler: handler,
+		config:  config,
 	}
 }
 
-// Start begins consuming messages in a goroutine
+// Start begins consuming messages in a goroutine, plus one per retry tier
 func (c *ConfluentKafkaConsumer) Start(ctx context.Context) {
-	c.wg.Add(1)
-	go func() {
-		defer c.wg.Done()
-		c.consume(ctx)
-	}()
+	for _, sub := range c.config.subscriptions() {
+		c.groups.expect(sub.GroupID)
+		c.wg.Add(1)
+		go func(sub subscription) {
+			defer c.wg.Done()
+			c.consume(ctx, sub)
+		}(sub)
+	}
 }
 
 // consume handles the actual message consumption
-func (c *ConfluentKafkaConsumer) consume(ctx context.Context) {
+func (c *ConfluentKafkaConsumer) consume(ctx context.Context, sub subscription) {
 	kafkaConfig := &kafka.ConfigMap{
-		"bootstrap.servers": c.config.BootstrapServers,
-		"group.id":          c.config.GroupID,
+		"bootstrap.servers": strings.Join(c.config.brokers(), ","),
+		"group.id":          sub.GroupID,
 		"auto.offset.reset": c.config.AutoOffsetReset,
 	}
 
+	// Records held for a retry tier must not exceed the maximum poll interval
+	if sub.MaxDelay > 0 {
+		maxPollInterval := sub.MaxDelay + time.Minute
+		if maxPollInterval < 5*time.Minute {
+			maxPollInterval = 5 * time.Minute
+		}
+		kafkaConfig.SetKey("max.poll.interval.ms", int(maxPollInterval.Milliseconds()))
+	}
+
+	// Offsets are committed by the consumer itself unless auto commit is requested,
+	// which then only commits the offsets stored once their messages were handled
+	mode := c.config.commitMode()
+	if mode != CommitModeAuto {
+		kafkaConfig.SetKey("enable.auto.commit", false)
+	}
+	kafkaConfig.SetKey("enable.auto.offset.store", false)
+
+	if c.config.Assignor != "" {
+		kafkaConfig.SetKey("partition.assignment.strategy", string(c.config.Assignor))
+	}
+
+	if err := applyConfluentSecurity(kafkaConfig, c.config.Security); err != nil {
+		logrus.WithError(err).Fatal("Invalid Confluent security configuration")
+		return
+	}
+
 	consumer, err := kafka.NewConsumer(kafkaConfig)
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to create consumer")
 		return
 	}
 
-	consumer.SubscribeTopics(c.config.Topics, nil)
+	// Records that failed processing are published with a dedicated producer
+	var publisher recordPublisher
+	if c.config.republishes() {
+		confluentPublisher, err := newConfluentRecordPublisher(c.config.brokers(), c.config.Security)
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to create dead-letter producer")
+			return
+		}
+		publisher = confluentPublisher
+	}
 
-	// Handle graceful shutdown
-	go func() {
-		<-ctx.Done()
-		logrus.Info("Context canceled, stopping consumer polling")
-		// This will cause consumer.Poll to return nil, breaking the loop
-		consumer.Close()
-	}()
+	processor := newMessageProcessor(c.handler, c.config, "confluent", publisher)
+	defer processor.close()
+
+	committer := newConfluentCommitter(consumer, c.config)
+
+	flow := newFlowController(c.config, "confluent", sub.GroupID,
+		func() { setConfluentPaused(consumer, true) },
+		func() { setConfluentPaused(consumer, false) },
+	)
+
+	dispatcher := newDispatcher(processor, c.config, flow)
+
+	// Messages gathered for the open batch in batch mode
+	batch := newConfluentBatch(c.config, func(messages []*kafka.Message) {
+		c.handleBatch(ctx, dispatcher, committer, messages)
+	})
+
+	// The rebalance callback runs inside Poll, so it never races with the consume loop
+	rebalance := &confluentRebalanceHandler{
+		groupID:    sub.GroupID,
+		dispatcher: dispatcher,
+		committer:  committer,
+		flow:       flow,
+		batch:      batch,
+	}
+	if err := consumer.SubscribeTopics(sub.Topics, rebalance.handle); err != nil {
+		logrus.WithError(err).Fatal("Failed to subscribe to topics")
+		return
+	}
+
+	c.lag.register(sub.GroupID, func(ctx context.Context) ([]PartitionLag, error) {
+		return confluentLag(ctx, consumer, sub.GroupID)
+	})
+	c.groups.track(sub.GroupID, rebalance.joined.Load)
 
 	logrus.WithFields(logrus.Fields{
 		"bootstrap_servers": c.config.BootstrapServers,
-		"group_id":          c.config.GroupID,
-		"topics":            c.config.Topics,
+		"group_id":          sub.GroupID,
+		"topics":            sub.Topics,
 	}).Info("Confluent Kafka consumer started and waiting for messages")
 
 	run := true
@@ -75,41 +146,48 @@ func (c *ConfluentKafkaConsumer) consume(ctx context.Context) {
 			logrus.Info("Shutdown signal received, stopping consumer")
 			run = false
 		default:
-			startTime := time.Now()
 			ev := consumer.Poll(100) // Poll with 100ms timeout
 
-			if ev == nil {
-				continue
-			}
-
 			switch e := ev.(type) {
-			case *kafka.Message:
-				messageID := uuid.New().String()
+			case nil:
+				committer.tick()
 
-				// Process the message using the domain service
-				description := "Note-" + messageID
-				_, err := c.orderService.CreateOrder(description, 1)
-				if err != nil {
-					logrus.WithError(err).Error("Error creating order")
+			case *kafka.Message:
+				if dispatcher.batching() {
+					batch.add(e)
+					break
 				}
 
-				// Calculate processing time in milliseconds
-				processingTimeMs := time.Since(startTime).Milliseconds()
-
-				logrus.WithFields(logrus.Fields{
-					"message_id":         messageID,
-					"processing_time_ms": processingTimeMs,
-					"topic":              *e.TopicPartition.Topic,
-					"partition":          e.TopicPartition.Partition,
-					"offset":             e.TopicPartition.Offset,
-				}).Info("Message processed")
+				err := dispatcher.dispatch(ctx, messageFromConfluent(e), func() {
+					committer.handled(e)
+				})
+				if err != nil && ctx.Err() != nil {
+					// Stop without committing the pending message
+					run = false
+				}
 
 			case kafka.Error:
 				logrus.WithError(e).Error("Kafka consumer error")
 			}
+
+			// Handle the open batch once it is full or its timeout expired
+			if batch.due() {
+				batch.flush()
+			}
 		}
 	}
 
+	// Let the workers finish, then commit handled offsets before leaving the group
+	dispatcher.close()
+	flow.close()
+	committer.flush()
+	c.lag.unregister(sub.GroupID)
+	c.groups.track(sub.GroupID, nil)
+
+	if err := consumer.Close(); err != nil {
+		logrus.WithError(err).Error("Error closing Confluent consumer")
+	}
+
 	logrus.Info("Confluent Kafka consumer loop exited")
 }
 
@@ -117,3 +195,405 @@ func (c *ConfluentKafkaConsumer) consume(ctx context.Context) {
 func (c *ConfluentKafkaConsumer) Wait() {
 	c.wg.Wait()
 }
+
+// Lag returns the lag of the partitions assigned to the consumer's clients
+func (c *ConfluentKafkaConsumer) Lag(ctx context.Context) ([]PartitionLag, error) {
+	return c.lag.lag(ctx)
+}
+
+// Groups reports whether the consumer's clients are members of their groups
+func (c *ConfluentKafkaConsumer) Groups() map[string]bool {
+	return c.groups.groups()
+}
+
+// confluentLag compares the offsets committed by the group with the high watermarks of the
+// assigned partitions. Queries wait until the context deadline, or confluentLagTimeout.
+func confluentLag(ctx context.Context, consumer *kafka.Consumer, groupID string) ([]PartitionLag, error) {
+	timeout := confluentLagTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	timeoutMs := int(timeout.Milliseconds())
+
+	assignment, err := consumer.Assignment()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assignment of group %s: %w", groupID, err)
+	}
+	if len(assignment) == 0 {
+		return nil, nil
+	}
+
+	committed, err := consumer.Committed(assignment, timeoutMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets of group %s: %w", groupID, err)
+	}
+
+	lags := make([]PartitionLag, 0, len(committed))
+	for _, tp := range committed {
+		if tp.Error != nil {
+			return nil, fmt.Errorf("failed to fetch offset of %s/%d: %w", *tp.Topic, tp.Partition, tp.Error)
+		}
+
+		logStart, highWatermark, err := consumer.QueryWatermarkOffsets(*tp.Topic, tp.Partition, timeoutMs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query watermarks of %s/%d: %w", *tp.Topic, tp.Partition, err)
+		}
+
+		// Partitions without a committed offset report kafka.OffsetInvalid
+		committedOffset := int64(-1)
+		if tp.Offset >= 0 {
+			committedOffset = int64(tp.Offset)
+		}
+
+		lags = append(lags, newPartitionLag(groupID, *tp.Topic, tp.Partition, committedOffset, logStart, highWatermark))
+	}
+
+	return lags, nil
+}
+
+// handleBatch handles a batch of messages and commits the ones that were handled
+func (c *ConfluentKafkaConsumer) handleBatch(ctx context.Context, dispatcher *dispatcher, committer *confluentCommitter, batch []*kafka.Message) {
+	msgs := make([]*Message, 0, len(batch))
+	for _, message := range batch {
+		msgs = append(msgs, messageFromConfluent(message))
+	}
+
+	handled, _ := dispatcher.dispatchBatch(ctx, msgs)
+	if handled > 0 {
+		committer.handled(batch[:handled]...)
+	}
+}
+
+// confluentBatch holds the messages gathered for the open batch in batch mode
+type confluentBatch struct {
+	size     int
+	timeout  time.Duration
+	messages []*kafka.Message
+	deadline time.Time
+	handle   func(messages []*kafka.Message)
+}
+
+// newConfluentBatch creates an empty batch passing full or expired batches to handle
+func newConfluentBatch(config *ConsumerConfig, handle func(messages []*kafka.Message)) *confluentBatch {
+	return &confluentBatch{
+		size:    config.BatchSize,
+		timeout: config.batchTimeout(),
+		handle:  handle,
+	}
+}
+
+// add appends a message, starting the timeout with the first message of a batch
+func (b *confluentBatch) add(message *kafka.Message) {
+	if len(b.messages) == 0 {
+		b.deadline = time.Now().Add(b.timeout)
+	}
+	b.messages = append(b.messages, message)
+}
+
+// due reports whether the open batch is full or its timeout expired
+func (b *confluentBatch) due() bool {
+	return len(b.messages) > 0 && (len(b.messages) >= b.size || time.Now().After(b.deadline))
+}
+
+// flush handles the open batch, if any
+func (b *confluentBatch) flush() {
+	if len(b.messages) == 0 {
+		return
+	}
+
+	b.handle(b.messages)
+	b.messages = b.messages[:0]
+}
+
+// discard drops the messages of the given partitions from the open batch
+func (b *confluentBatch) discard(partitions []kafka.TopicPartition) {
+	dropped := make(map[topicPartition]bool, len(partitions))
+	for _, tp := range partitions {
+		if tp.Topic != nil {
+			dropped[topicPartition{topic: *tp.Topic, partition: tp.Partition}] = true
+		}
+	}
+
+	kept := b.messages[:0]
+	for _, message := range b.messages {
+		tp := message.TopicPartition
+		if !dropped[topicPartition{topic: *tp.Topic, partition: tp.Partition}] {
+			kept = append(kept, message)
+		}
+	}
+	b.messages = kept
+}
+
+// confluentRebalanceHandler reacts to partition assignment changes of a Confluent consumer
+type confluentRebalanceHandler struct {
+	groupID    string
+	dispatcher *dispatcher
+	committer  *confluentCommitter
+	flow       *flowController
+	batch      *confluentBatch
+	// joined is set by an assignment and cleared when an eager rebalance revokes it or it is lost
+	joined atomic.Bool
+}
+
+// handle is the Confluent rebalance callback
+func (h *confluentRebalanceHandler) handle(consumer *kafka.Consumer, ev kafka.Event) error {
+	cooperative := consumer.GetRebalanceProtocol() == "COOPERATIVE"
+
+	switch e := ev.(type) {
+	case kafka.AssignedPartitions:
+		logPartitions("confluent", h.groupID, "assigned", confluentPartitionMap(e.Partitions))
+
+		// Apply the assignment now so paused fetching can be reapplied to it
+		var err error
+		if cooperative {
+			err = consumer.IncrementalAssign(e.Partitions)
+		} else {
+			err = consumer.Assign(e.Partitions)
+		}
+		if err != nil {
+			return err
+		}
+		h.flow.reapply()
+		h.joined.Store(true)
+
+	case kafka.RevokedPartitions:
+		if consumer.AssignmentLost() {
+			// The partitions already have a new owner, committing would fail
+			logPartitions("confluent", h.groupID, "lost", confluentPartitionMap(e.Partitions))
+			h.batch.discard(e.Partitions)
+			h.dispatcher.drain()
+			h.joined.Store(false)
+			return nil
+		}
+
+		logPartitions("confluent", h.groupID, "revoked", confluentPartitionMap(e.Partitions))
+
+		// Handle the open batch and in-flight work, then commit it before the library
+		// unassigns the partitions
+		h.batch.flush()
+		h.dispatcher.drain()
+		h.committer.flush()
+
+		// Cooperative rebalances only revoke part of the assignment and keep the membership
+		if !cooperative {
+			h.joined.Store(false)
+		}
+	}
+
+	return nil
+}
+
+// confluentPartitionMap groups Confluent topic partitions by topic
+func confluentPartitionMap(partitions []kafka.TopicPartition) map[string][]int32 {
+	grouped := make(map[string][]int32)
+	for _, tp := range partitions {
+		if tp.Topic != nil {
+			grouped[*tp.Topic] = append(grouped[*tp.Topic], tp.Partition)
+		}
+	}
+	return grouped
+}
+
+// setConfluentPaused pauses or resumes fetching on all currently assigned partitions
+func setConfluentPaused(consumer *kafka.Consumer, paused bool) {
+	partitions, err := consumer.Assignment()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to get Confluent assignment")
+		return
+	}
+
+	if paused {
+		err = consumer.Pause(partitions)
+	} else {
+		err = consumer.Resume(partitions)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to change Confluent partition fetching")
+	}
+}
+
+// messageFromConfluent converts a Confluent message into a client-agnostic message
+func messageFromConfluent(message *kafka.Message) *Message {
+	headers := make([]Header, 0, len(message.Headers))
+	for _, h := range message.Headers {
+		headers = append(headers, Header{Key: h.Key, Value: h.Value})
+	}
+
+	topic := ""
+	if message.TopicPartition.Topic != nil {
+		topic = *message.TopicPartition.Topic
+	}
+
+	return &Message{
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   headers,
+		Topic:     topic,
+		Partition: message.TopicPartition.Partition,
+		Offset:    int64(message.TopicPartition.Offset),
+		Timestamp: message.Timestamp,
+	}
+}
+
+// confluentRecordPublisher publishes records through a dedicated Confluent producer
+type confluentRecordPublisher struct {
+	producer *kafka.Producer
+}
+
+// newConfluentRecordPublisher creates a producer waiting for all in-sync replicas
+func newConfluentRecordPublisher(brokers []string, security SecurityConfig) (*confluentRecordPublisher, error) {
+	config := &kafka.ConfigMap{
+		"bootstrap.servers": strings.Join(brokers, ","),
+		"acks":              "all",
+	}
+	if err := applyConfluentSecurity(config, security); err != nil {
+		return nil, err
+	}
+
+	producer, err := kafka.NewProducer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delivery reports go to per-message channels, only log client level errors here
+	go func() {
+		for e := range producer.Events() {
+			if kafkaErr, ok := e.(kafka.Error); ok {
+				logrus.WithError(kafkaErr).Error("Dead-letter producer error")
+			}
+		}
+	}()
+
+	return &confluentRecordPublisher{producer: producer}, nil
+}
+
+// publish writes a record to the given topic and waits for its delivery report
+func (p *confluentRecordPublisher) publish(ctx context.Context, topic string, key, value []byte, headers []Header) error {
+	deliveryChan := make(chan kafka.Event, 1)
+
+	err := p.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Headers:        toConfluentHeaders(headers),
+	}, deliveryChan)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case e := <-deliveryChan:
+		if m, ok := e.(*kafka.Message); ok && m.TopicPartition.Error != nil {
+			return m.TopicPartition.Error
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close flushes pending records and shuts the producer down
+func (p *confluentRecordPublisher) close() {
+	if unflushed := p.producer.Flush(5000); unflushed > 0 {
+		logrus.Warnf("%d dead-letter messages were not flushed before timeout", unflushed)
+	}
+	p.producer.Close()
+}
+
+// confluentCommitter commits the offsets of handled messages according to the commit mode.
+// It is safe for concurrent use by the consume loop and the workers.
+type confluentCommitter struct {
+	mu       sync.Mutex
+	consumer *kafka.Consumer
+	mode     CommitMode
+	batch    *commitBatch
+}
+
+// newConfluentCommitter creates a committer for the configured commit mode
+func newConfluentCommitter(consumer *kafka.Consumer, config *ConsumerConfig) *confluentCommitter {
+	return &confluentCommitter{
+		consumer: consumer,
+		mode:     config.commitMode(),
+		batch:    newCommitBatch(config),
+	}
+}
+
+// handled records that the messages were handled and commits according to the mode
+func (c *confluentCommitter) handled(messages ...*kafka.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.mode {
+	case CommitModeAuto:
+		if _, err := c.consumer.StoreOffsets(nextOffsets(messages)); err != nil {
+			logrus.WithError(err).Error("Failed to store Confluent offset")
+		}
+	case CommitModeAfterSuccess:
+		if _, err := c.consumer.CommitOffsets(nextOffsets(messages)); err != nil {
+			logrus.WithError(err).Error("Failed to commit Confluent offset")
+		}
+	case CommitModeManualBatch:
+		if _, err := c.consumer.StoreOffsets(nextOffsets(messages)); err != nil {
+			logrus.WithError(err).Error("Failed to store Confluent offset")
+			return
+		}
+		if c.batch.add(len(messages)) {
+			c.flushLocked()
+		}
+	}
+}
+
+// tick commits stored offsets in manual batch mode once the interval has passed
+func (c *confluentCommitter) tick() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.mode == CommitModeManualBatch && c.batch.due() {
+		c.flushLocked()
+	}
+}
+
+// flush commits all stored offsets in manual batch mode
+func (c *confluentCommitter) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flushLocked()
+}
+
+// flushLocked is flush for callers already holding the lock
+func (c *confluentCommitter) flushLocked() {
+	if c.mode != CommitModeManualBatch || c.batch.pending == 0 {
+		return
+	}
+
+	if _, err := c.consumer.Commit(); err != nil {
+		// The messages will be redelivered, which at-least-once processing tolerates
+		logrus.WithError(err).Error("Failed to commit Confluent offsets")
+	}
+	c.batch.reset()
+}
+
+// nextOffsets returns the offset following the last message of each partition
+func nextOffsets(messages []*kafka.Message) []kafka.TopicPartition {
+	offsets := make([]kafka.TopicPartition, 0, len(messages))
+	index := make(map[topicPartition]int)
+
+	for _, message := range messages {
+		next := message.TopicPartition
+		next.Offset++
+
+		key := topicPartition{topic: *next.Topic, partition: next.Partition}
+		if i, ok := index[key]; ok {
+			if next.Offset > offsets[i].Offset {
+				offsets[i] = next
+			}
+			continue
+		}
+
+		index[key] = len(offsets)
+		offsets = append(offsets, next)
+	}
+
+	return offsets
+}
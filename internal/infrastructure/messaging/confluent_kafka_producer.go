@@ -4,30 +4,34 @@ import (
 	"context"
 	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"goEvents/internal/correlation"
+	"goEvents/internal/infrastructure/messaging/event"
+	"strings"
 	"sync"
+	"time"
 )
 
+// confluentPingTimeout bounds the metadata request of a ping without a context deadline
+const confluentPingTimeout = 5 * time.Second
+
 // ConfluentKafkaProducer implements the MessageProducer interface using Confluent's Kafka client
 type ConfluentKafkaProducer struct {
 	producer    *kafka.Producer
 	mutex       sync.Mutex
 	initialized bool
-	config      *kafka.ConfigMap
+	config      *ProducerConfig
 }
 
 // NewConfluentKafkaProducer creates a new Kafka producer using Confluent's library
-func NewConfluentKafkaProducer(bootstrapServers string) *ConfluentKafkaProducer {
-	if bootstrapServers == "" {
-		bootstrapServers = "localhost:9092"
+func NewConfluentKafkaProducer(config *ProducerConfig) *ConfluentKafkaProducer {
+	if config == nil {
+		logrus.Fatal("Kafka configuration must be provided")
 	}
 
 	return &ConfluentKafkaProducer{
 		initialized: false,
-		config: &kafka.ConfigMap{
-			"bootstrap.servers": bootstrapServers,
-		},
+		config:      config,
 	}
 }
 
@@ -40,53 +44,122 @@ func (p *ConfluentKafkaProducer) Initialize() error {
 		return nil
 	}
 
-	var err error
-	p.producer, err = kafka.NewProducer(p.config)
+	config, err := confluentProducerConfig(p.config)
+	if err != nil {
+		return err
+	}
+
+	producer, err := kafka.NewProducer(config)
 	if err != nil {
 		return fmt.Errorf("failed to create Confluent Kafka producer: %w", err)
 	}
+	p.producer = producer
 
-	// Start a goroutine to handle delivery reports
+	// Delivery reports go to the channel of each publish, only client errors arrive here.
+	// The goroutine keeps its own reference, Shutdown clears the field.
 	go func() {
-		for e := range p.producer.Events() {
-			switch ev := e.(type) {
-			case *kafka.Message:
-				if ev.TopicPartition.Error != nil {
-					logrus.WithError(ev.TopicPartition.Error).Error("Failed to deliver message")
-				} else {
-					logrus.WithFields(logrus.Fields{
-						"topic":     *ev.TopicPartition.Topic,
-						"partition": ev.TopicPartition.Partition,
-						"offset":    ev.TopicPartition.Offset,
-					}).Debug("Message delivered successfully")
-				}
+		for e := range producer.Events() {
+			if err, ok := e.(kafka.Error); ok {
+				logrus.WithError(err).Error("Confluent Kafka producer error")
 			}
 		}
 	}()
 
+	logrus.WithFields(logrus.Fields{
+		"bootstrap_servers": p.config.BootstrapServers,
+		"topic":             p.config.Topic,
+	}).Info("Confluent Kafka producer initialized")
+
 	p.initialized = true
 	return nil
 }
 
-// PublishOrder publishes order messages to Kafka
-func (p *ConfluentKafkaProducer) PublishOrder(orderID string) error {
+// PublishOrder publishes an order event and waits for its delivery report
+func (p *ConfluentKafkaProducer) PublishOrder(ctx context.Context, evt *event.OrderEvent) (err error) {
 	if err := p.Initialize(); err != nil {
 		return err
 	}
 
-	topic := "orders"
-	for i := 0; i < 100000; i++ {
-		msg := &kafka.Message{
-			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-			Key:            []byte(uuid.New().String()),
-			Value:          []byte(orderID),
-		}
+	value, err := event.Encode(evt)
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	topic := p.config.Topic
+
+	ctx, span := startPublishSpan(ctx, topic)
+	defer func() { endSpan(span, err) }()
+
+	// Every record carries the correlation ID and the trace context of the span
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            evt.Key(),
+		Value:          value,
+		Headers:        toConfluentHeaders(orderEventHeaders(ctx, evt)),
+	}
+
+	// The delivery report goes to this channel instead of the Events channel, so the
+	// publish returns once the brokers acknowledged the record like the other clients
+	delivery := make(chan kafka.Event, 1)
+	if err := p.producer.Produce(msg, delivery); err != nil {
+		observeProduced("confluent", topic, 0, err)
+		correlation.Logger(ctx).WithError(err).Error("Failed to produce message")
+		return err
+	}
 
-		err := p.producer.Produce(msg, nil)
+	select {
+	case e := <-delivery:
+		report, ok := e.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery report %v", e)
+		}
+		err = report.TopicPartition.Error
+		observeProduced("confluent", topic, time.Since(startTime), err)
 		if err != nil {
-			logrus.WithError(err).Error("Failed to produce message")
+			correlation.Logger(ctx).WithError(err).Error("Failed to deliver message")
 			return err
 		}
+
+		correlation.Logger(ctx).WithFields(logrus.Fields{
+			"event_id":   evt.EventID,
+			"event_type": evt.EventType,
+			"partition":  report.TopicPartition.Partition,
+			"offset":     report.TopicPartition.Offset,
+		}).Debug("Message delivered with Confluent")
+		return nil
+	case <-ctx.Done():
+		// The record stays queued and may still be delivered
+		return ctx.Err()
+	}
+}
+
+// Ping fetches the metadata of the configured topic with the producer's client. The
+// request waits until the context deadline, or confluentPingTimeout without one.
+func (p *ConfluentKafkaProducer) Ping(ctx context.Context) error {
+	p.mutex.Lock()
+	producer := p.producer
+	p.mutex.Unlock()
+
+	if producer == nil {
+		return ErrProducerNotInitialized
+	}
+
+	timeout := confluentPingTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	topic := p.config.Topic
+	metadata, err := producer.GetMetadata(&topic, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("failed to fetch metadata: %w", err)
+	}
+	if len(metadata.Brokers) == 0 {
+		return errNoBrokers
+	}
+	if t, ok := metadata.Topics[topic]; ok && t.Error.Code() != kafka.ErrNoError {
+		return fmt.Errorf("topic %s: %w", topic, t.Error)
 	}
 
 	return nil
@@ -103,16 +176,17 @@ func (p *ConfluentKafkaProducer) Shutdown(ctx context.Context) {
 
 	// Create a done channel to signal when flushing is complete
 	done := make(chan bool)
+	producer := p.producer
 
 	go func() {
 		// Flush remaining messages
-		unflushed := p.producer.Flush(5000) // Wait up to 5 seconds
+		unflushed := producer.Flush(5000) // Wait up to 5 seconds
 		if unflushed > 0 {
 			logrus.Warnf("%d messages were not flushed before timeout", unflushed)
 		}
 
 		// Close the producer
-		p.producer.Close()
+		producer.Close()
 		logrus.Info("Kafka producer closed")
 
 		close(done)
@@ -129,3 +203,71 @@ func (p *ConfluentKafkaProducer) Shutdown(ctx context.Context) {
 	p.initialized = false
 	p.producer = nil
 }
+
+// confluentProducerConfig maps the producer configuration into librdkafka properties
+func confluentProducerConfig(config *ProducerConfig) (*kafka.ConfigMap, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	acks := map[Acks]string{AcksAll: "all", AcksLeader: "1", AcksNone: "0"}
+
+	// The *_random partitioners spread records without a key instead of using one partition
+	partitioners := map[Partitioner]string{PartitionerMurmur2: "murmur2_random", PartitionerRandom: "random"}
+
+	kafkaConfig := &kafka.ConfigMap{
+		"bootstrap.servers":  strings.Join(config.brokers(), ","),
+		"acks":               acks[config.acks()],
+		"compression.type":   string(config.compression()),
+		"linger.ms":          int(config.Linger.Milliseconds()),
+		"batch.size":         config.batchMaxBytes(),
+		"enable.idempotence": config.Idempotent,
+		"retries":            config.Retries,
+		"partitioner":        partitioners[config.partitioner()],
+	}
+
+	if err := applyConfluentSecurity(kafkaConfig, config.Security); err != nil {
+		return nil, err
+	}
+
+	return kafkaConfig, nil
+}
+
+// applyConfluentSecurity maps the security configuration into librdkafka properties.
+// librdkafka reads the certificate files itself when the client is created.
+func applyConfluentSecurity(config *kafka.ConfigMap, security SecurityConfig) error {
+	if err := security.Validate(); err != nil {
+		return err
+	}
+
+	config.SetKey("security.protocol", security.protocol())
+
+	if security.TLS.CAFile != "" {
+		config.SetKey("ssl.ca.location", security.TLS.CAFile)
+	}
+	if security.TLS.CertFile != "" {
+		config.SetKey("ssl.certificate.location", security.TLS.CertFile)
+		config.SetKey("ssl.key.location", security.TLS.KeyFile)
+	}
+	if security.TLS.InsecureSkipVerify {
+		config.SetKey("enable.ssl.certificate.verification", false)
+		config.SetKey("ssl.endpoint.identification.algorithm", "none")
+	}
+
+	if security.SASL.enabled() {
+		config.SetKey("sasl.mechanisms", string(security.SASL.Mechanism))
+		config.SetKey("sasl.username", security.SASL.Username)
+		config.SetKey("sasl.password", security.SASL.Password)
+	}
+
+	return nil
+}
+
+// toConfluentHeaders converts client-agnostic headers into Confluent record headers
+func toConfluentHeaders(headers []Header) []kafka.Header {
+	result := make([]kafka.Header, 0, len(headers))
+	for _, h := range headers {
+		result = append(result, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+	return result
+}
@@ -11,4 +11,12 @@ type MessageConsumer interface {
 
 	// Wait waits for all consumer goroutines to finish
 	Wait()
+
+	// Lag returns the committed offset, high watermark and lag of every partition
+	// currently assigned to this consumer, across its consumer groups
+	Lag(ctx context.Context) ([]PartitionLag, error)
+
+	// Groups reports for every consumer group of the consumer whether its client is
+	// currently a member of the group
+	Groups() map[string]bool
 }
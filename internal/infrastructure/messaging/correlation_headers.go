@@ -0,0 +1,37 @@
+package messaging
+
+import (
+	"context"
+	"goEvents/internal/correlation"
+)
+
+// correlationHeaders returns the record headers carrying the correlation ID and trace context of ctx
+func correlationHeaders(ctx context.Context) []Header {
+	var headers []Header
+	if id := correlation.ID(ctx); id != "" {
+		headers = append(headers, Header{Key: correlation.KafkaHeader, Value: []byte(id)})
+	}
+	if tp, ok := correlation.TraceParentFromContext(ctx); ok {
+		headers = append(headers, Header{Key: correlation.TraceParentHeader, Value: []byte(tp.String())})
+	}
+	return headers
+}
+
+// messageContext returns the context for handling a consumed message. It continues the
+// correlation ID and trace of the producer, or starts new ones for records without them.
+func messageContext(ctx context.Context, msg *Message) context.Context {
+	id, _ := msg.Header(correlation.KafkaHeader)
+	traceParent, _ := msg.Header(correlation.TraceParentHeader)
+	return correlation.Continue(ctx, id, traceParent)
+}
+
+// batchCorrelationIDs returns the correlation IDs carried by the messages of a batch
+func batchCorrelationIDs(msgs []*Message) []string {
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		if id, ok := msg.Header(correlation.KafkaHeader); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
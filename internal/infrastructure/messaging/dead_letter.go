@@ -0,0 +1,90 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+// Header names added to records forwarded to the dead-letter topic
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderErrorMessage      = "x-error-message"
+	HeaderFailureCount      = "x-failure-count"
+	HeaderFailedAt          = "x-failed-at"
+)
+
+// recordPublisher sends a record to a topic using the consumer's Kafka client
+type recordPublisher interface {
+	// publish synchronously writes a record and returns once the broker acknowledged it
+	publish(ctx context.Context, topic string, key, value []byte, headers []Header) error
+
+	// close releases any resources held by the publisher
+	close()
+}
+
+// deadLetterQueue forwards records that failed processing to a dead-letter topic
+type deadLetterQueue struct {
+	topic     string
+	publisher recordPublisher
+}
+
+// send republishes the message unchanged with headers describing the failure
+func (d *deadLetterQueue) send(ctx context.Context, msg *Message, cause error) error {
+	headers := failureHeaders(msg, cause, time.Now())
+
+	if err := d.publisher.publish(ctx, d.topic, msg.Key, msg.Value, headers); err != nil {
+		return fmt.Errorf("failed to publish to dead-letter topic %s: %w", d.topic, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"dead_letter_topic": d.topic,
+		"topic":             msg.Topic,
+		"partition":         msg.Partition,
+		"offset":            msg.Offset,
+	}).Warn("Message sent to dead-letter topic")
+
+	return nil
+}
+
+// failureHeaders returns the message headers with the failure metadata replaced.
+// The origin of a record that already failed before is kept, and the failure count
+// is incremented, so a record keeps pointing at where it was first consumed.
+func failureHeaders(msg *Message, cause error, now time.Time) []Header {
+	originTopic, hasOrigin := msg.Header(HeaderOriginalTopic)
+	originPartition, _ := msg.Header(HeaderOriginalPartition)
+	originOffset, _ := msg.Header(HeaderOriginalOffset)
+	if !hasOrigin {
+		originTopic = msg.Topic
+		originPartition = strconv.FormatInt(int64(msg.Partition), 10)
+		originOffset = strconv.FormatInt(msg.Offset, 10)
+	}
+
+	failures := 0
+	if value, ok := msg.Header(HeaderFailureCount); ok {
+		failures, _ = strconv.Atoi(value)
+	}
+
+	headers := make([]Header, 0, len(msg.Headers)+6)
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset,
+			HeaderErrorMessage, HeaderFailureCount, HeaderFailedAt:
+			continue
+		}
+		headers = append(headers, h)
+	}
+
+	return append(headers,
+		Header{Key: HeaderOriginalTopic, Value: []byte(originTopic)},
+		Header{Key: HeaderOriginalPartition, Value: []byte(originPartition)},
+		Header{Key: HeaderOriginalOffset, Value: []byte(originOffset)},
+		Header{Key: HeaderErrorMessage, Value: []byte(cause.Error())},
+		Header{Key: HeaderFailureCount, Value: []byte(strconv.Itoa(failures + 1))},
+		Header{Key: HeaderFailedAt, Value: []byte(now.UTC().Format(time.RFC3339Nano))},
+	)
+}
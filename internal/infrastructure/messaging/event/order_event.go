@@ -0,0 +1,137 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"goEvents/internal/domain/model"
+	"strconv"
+	"time"
+)
+
+// SchemaVersion is the version of the order event envelope written by this service
+const SchemaVersion = 1
+
+// Event types carried in the envelope
+const (
+	// TypeOrderPlaced asks consumers to create a new order from the payload
+	TypeOrderPlaced = "order.placed"
+	// TypeOrderCreated reports that an order was persisted
+	TypeOrderCreated = "order.created"
+	// TypeOrderUpdated reports that the details of an order changed
+	TypeOrderUpdated = "order.updated"
+	// TypeOrderStatusChanged reports that an order moved to a new status
+	TypeOrderStatusChanged = "order.status_changed"
+	// TypeOrderDeleted reports that an order was removed
+	TypeOrderDeleted = "order.deleted"
+)
+
+// Header names set on every record carrying an order event
+const (
+	HeaderEventType     = "event-type"
+	HeaderSchemaVersion = "schema-version"
+	HeaderContentType   = "content-type"
+
+	// ContentTypeJSON is the content type of the encoded envelope
+	ContentTypeJSON = "application/json"
+)
+
+var (
+	// ErrInvalidEvent is returned when a payload is not a well-formed order event
+	ErrInvalidEvent = errors.New("invalid order event")
+	// ErrUnsupportedSchemaVersion is returned when a payload was written with an unknown schema version
+	ErrUnsupportedSchemaVersion = errors.New("unsupported order event schema version")
+)
+
+// OrderEvent is the versioned envelope published on the wire for every order event
+type OrderEvent struct {
+	EventType     string       `json:"event_type"`
+	EventID       string       `json:"event_id"`
+	SchemaVersion int          `json:"schema_version"`
+	OccurredAt    time.Time    `json:"occurred_at"`
+	Order         OrderPayload `json:"order"`
+}
+
+// OrderPayload is the order carried by an OrderEvent
+type OrderPayload struct {
+	ID          uint   `json:"id,omitempty"`
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	Status      string `json:"status,omitempty"`
+}
+
+// NewOrderEvent creates an event of the given type for the order
+func NewOrderEvent(eventType string, order *model.Order) *OrderEvent {
+	return &OrderEvent{
+		EventType:     eventType,
+		EventID:       uuid.New().String(),
+		SchemaVersion: SchemaVersion,
+		OccurredAt:    time.Now().UTC(),
+		Order: OrderPayload{
+			ID:          order.ID,
+			Description: order.Description,
+			Quantity:    order.Quantity,
+			Status:      string(order.Status),
+		},
+	}
+}
+
+// Key returns the record key for the event. Events for persisted orders are
+// keyed by order ID so they keep their relative order within a partition.
+func (e *OrderEvent) Key() []byte {
+	if e.Order.ID != 0 {
+		return []byte(strconv.FormatUint(uint64(e.Order.ID), 10))
+	}
+	return []byte(e.EventID)
+}
+
+// ToModel converts the payload into a domain order
+func (e *OrderEvent) ToModel() *model.Order {
+	return &model.Order{
+		ID:          e.Order.ID,
+		Description: e.Order.Description,
+		Quantity:    e.Order.Quantity,
+		Status:      model.OrderStatus(e.Order.Status),
+	}
+}
+
+// Encode serializes the event into its wire format
+func Encode(e *OrderEvent) ([]byte, error) {
+	if err := validate(e); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode order event: %w", err)
+	}
+	return data, nil
+}
+
+// Decode parses an event from its wire format and validates the envelope
+func Decode(data []byte) (*OrderEvent, error) {
+	var e OrderEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+
+	if err := validate(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// validate checks the envelope fields shared by all event types
+func validate(e *OrderEvent) error {
+	if e.SchemaVersion < 1 || e.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedSchemaVersion, e.SchemaVersion)
+	}
+	if e.EventType == "" {
+		return fmt.Errorf("%w: missing event_type", ErrInvalidEvent)
+	}
+	if e.EventID == "" {
+		return fmt.Errorf("%w: missing event_id", ErrInvalidEvent)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Client selects the Kafka client library behind a producer or consumer
+type Client string
+
+const (
+	ClientFranz     Client = "franz"
+	ClientSarama    Client = "sarama"
+	ClientConfluent Client = "confluent"
+)
+
+var (
+	// ErrUnknownClient is returned for a client name other than franz, sarama or confluent
+	ErrUnknownClient = errors.New("unknown Kafka client")
+	// ErrUnsupportedFeature is returned when the configuration asks for a feature the client lacks
+	ErrUnsupportedFeature = errors.New("feature not supported by Kafka client")
+)
+
+// clientFeatures lists the optional features a client implements
+type clientFeatures struct {
+	// transactions enables the exactly-once transactional consumer
+	transactions bool
+	// cooperativeRebalance enables AssignorCooperativeSticky with incremental rebalances
+	cooperativeRebalance bool
+}
+
+// supportedFeatures holds the features of every known client
+var supportedFeatures = map[Client]clientFeatures{
+	ClientFranz:     {transactions: true, cooperativeRebalance: true},
+	ClientSarama:    {transactions: true},
+	ClientConfluent: {cooperativeRebalance: true},
+}
+
+// ParseClient returns the client with the given name
+func ParseClient(name string) (Client, error) {
+	client := Client(name)
+	if _, ok := supportedFeatures[client]; !ok {
+		return "", fmt.Errorf("%w %q, expected %q, %q or %q", ErrUnknownClient, name, ClientFranz, ClientSarama, ClientConfluent)
+	}
+	return client, nil
+}
+
+// NewProducer creates a producer using the given client. All clients support every
+// ProducerConfig setting, so only the configuration itself is validated.
+func NewProducer(client Client, config *ProducerConfig) (MessageProducer, error) {
+	if _, err := ParseClient(string(client)); err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, fmt.Errorf("%w: configuration must be provided", ErrInvalidProducerConfig)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch client {
+	case ClientSarama:
+		return NewSaramaKafkaProducer(config), nil
+	case ClientConfluent:
+		return NewConfluentKafkaProducer(config), nil
+	default:
+		return NewFranzKafkaProducer(config), nil
+	}
+}
+
+// CheckConsumer validates the configuration and checks that the client supports every
+// feature it asks for, so a consumer created by NewConsumer only fails on its handler
+func CheckConsumer(client Client, config *ConsumerConfig) error {
+	features, err := consumerFeatures(client, config)
+	if err != nil {
+		return err
+	}
+
+	if config.Assignor == AssignorCooperativeSticky && !features.cooperativeRebalance {
+		return unsupported(client, "the cooperative-sticky assignor, choose range or roundrobin")
+	}
+	return nil
+}
+
+// NewConsumer creates a consumer using the given client after checking that the
+// client supports everything the configuration and handler ask for
+func NewConsumer(client Client, handler MessageHandler, config *ConsumerConfig) (MessageConsumer, error) {
+	if err := CheckConsumer(client, config); err != nil {
+		return nil, err
+	}
+
+	if config.BatchSize > 1 {
+		if _, ok := handler.(BatchHandler); !ok {
+			return nil, fmt.Errorf("%w: BatchSize requires a BatchHandler", ErrInvalidConsumerConfig)
+		}
+	}
+
+	switch client {
+	case ClientSarama:
+		return NewSaramaKafkaConsumer(handler, config), nil
+	case ClientConfluent:
+		return NewConfluentKafkaConsumer(handler, config), nil
+	default:
+		return NewFranzKafkaConsumer(handler, config), nil
+	}
+}
+
+// NewTransactionalConsumer creates an exactly-once consumer using the given client.
+// Settings the transactional consumers ignore are rejected rather than silently dropped.
+func NewTransactionalConsumer(client Client, handler TransformHandler, config *ConsumerConfig) (MessageConsumer, error) {
+	features, err := consumerFeatures(client, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if !features.transactions {
+		return nil, unsupported(client, "transactional consumers")
+	}
+	if config.TransactionalID == "" {
+		return nil, ErrMissingTransactionalID
+	}
+	if config.DeadLetterTopic == "" {
+		return nil, ErrMissingDeadLetterTopic
+	}
+
+	switch {
+	case config.Assignor != "":
+		return nil, notTransactional("Assignor")
+	case len(config.RetryTiers) > 0:
+		return nil, notTransactional("RetryTiers")
+	case config.commitMode() != CommitModeAuto:
+		return nil, notTransactional("CommitMode")
+	case config.Workers > 1:
+		return nil, notTransactional("Workers")
+	case config.BatchSize > 1:
+		return nil, notTransactional("BatchSize")
+	case config.Backpressure.enabled():
+		return nil, notTransactional("Backpressure")
+	}
+
+	if client == ClientSarama {
+		return NewSaramaTransactionalConsumer(handler, config), nil
+	}
+	return NewFranzTransactionalConsumer(handler, config), nil
+}
+
+// consumerFeatures validates the client and configuration and returns the client's features
+func consumerFeatures(client Client, config *ConsumerConfig) (clientFeatures, error) {
+	if _, err := ParseClient(string(client)); err != nil {
+		return clientFeatures{}, err
+	}
+	if config == nil {
+		return clientFeatures{}, fmt.Errorf("%w: configuration must be provided", ErrInvalidConsumerConfig)
+	}
+	if err := config.Validate(); err != nil {
+		return clientFeatures{}, err
+	}
+	return supportedFeatures[client], nil
+}
+
+// notTransactional builds the error for a setting the transactional consumers ignore
+func notTransactional(setting string) error {
+	return fmt.Errorf("%w: %s does not apply to transactional consumers", ErrInvalidConsumerConfig, setting)
+}
+
+// unsupported builds the error for a feature the client does not implement
+func unsupported(client Client, feature string) error {
+	return fmt.Errorf("%w: %s does not support %s", ErrUnsupportedFeature, client, feature)
+}
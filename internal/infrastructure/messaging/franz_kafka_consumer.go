@@ -2,50 +2,55 @@ package messaging
 
 import (
 	"context"
-	"github.com/google/uuid"
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo"
-	"goEvents/internal/domain/service"
+	"github.com/twmb/franz-go/pkg/kmsg"
 	"sync"
 	"time"
 )
 
 // FranzKafkaConsumer implements the MessageConsumer interface using Franz-Go Kafka client
 type FranzKafkaConsumer struct {
-	orderService *service.OrderService
-	config       *ConsumerConfig
-	client       *kgo.Client
-	wg           sync.WaitGroup
+	handler MessageHandler
+	config  *ConsumerConfig
+	wg      sync.WaitGroup
+	lag     lagRegistry
+	groups  groupMembership
 }
 
-// NewFranzKafkaConsumer creates a new Kafka consumer with the given order service
-func NewFranzKafkaConsumer(orderService *service.OrderService, config *ConsumerConfig) *FranzKafkaConsumer {
+// NewFranzKafkaConsumer creates a new Kafka consumer passing messages to the given handler
+func NewFranzKafkaConsumer(handler MessageHandler, config *ConsumerConfig) *FranzKafkaConsumer {
 	if config == nil {
 		logrus.Fatal("Kafka configuration must be provided")
 	}
 
 	return &FranzKafkaConsumer{
-		orderService: orderService,
-		config:       config,
+		handler: handler,
+		config:  config,
 	}
 }
 
-// Start begins consuming messages in a goroutine
+// Start begins consuming messages in a goroutine, plus one per retry tier
 func (c *FranzKafkaConsumer) Start(ctx context.Context) {
-	c.wg.Add(1)
-	go func() {
-		defer c.wg.Done()
-		c.consume(ctx)
-	}()
+	for _, sub := range c.config.subscriptions() {
+		c.groups.expect(sub.GroupID)
+		c.wg.Add(1)
+		go func(sub subscription) {
+			defer c.wg.Done()
+			c.consume(ctx, sub)
+		}(sub)
+	}
 }
 
 // consume handles the actual message consumption
-func (c *FranzKafkaConsumer) consume(ctx context.Context) {
+func (c *FranzKafkaConsumer) consume(ctx context.Context, sub subscription) {
 	// Create Franz-Go client configuration
 	opts := []kgo.Opt{
-		kgo.SeedBrokers(c.config.BootstrapServers),
-		kgo.ConsumerGroup(c.config.GroupID),
-		kgo.ConsumeTopics(c.config.Topics...),
+		kgo.SeedBrokers(c.config.brokers()...),
+		kgo.ConsumerGroup(sub.GroupID),
+		kgo.ConsumeTopics(sub.Topics...),
 	}
 
 	// Set initial offset based on configuration
@@ -55,19 +60,85 @@ func (c *FranzKafkaConsumer) consume(ctx context.Context) {
 		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()))
 	}
 
+	// Offsets are committed by the consumer itself unless auto commit is requested,
+	// which then only commits the records marked as handled
+	if c.config.commitMode() != CommitModeAuto {
+		opts = append(opts, kgo.DisableAutoCommit())
+	} else {
+		opts = append(opts, kgo.AutoCommitMarks())
+	}
+
+	if balancer, ok := franzBalancer(c.config.Assignor); ok {
+		opts = append(opts, kgo.Balancers(balancer))
+	}
+
+	// Records held for a retry tier must not exceed the rebalance timeout
+	if sub.MaxDelay > 0 {
+		opts = append(opts, kgo.RebalanceTimeout(sub.MaxDelay+time.Minute))
+	}
+
+	// Rebalances wait until the polled records were dispatched, so that revoking
+	// partitions can flush in-flight work and commit it first
+	rebalance := &franzRebalanceHandler{groupID: sub.GroupID, assignment: newAssignedPartitions()}
+	opts = append(opts,
+		kgo.BlockRebalanceOnPoll(),
+		kgo.OnPartitionsAssigned(rebalance.assigned),
+		kgo.OnPartitionsRevoked(rebalance.revoked),
+		kgo.OnPartitionsLost(rebalance.lost),
+	)
+
+	securityOpts, err := franzSecurityOpts(c.config.Security)
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid Franz-Go security configuration")
+		return
+	}
+	opts = append(opts, securityOpts...)
+
 	// Create new client
 	client, err := kgo.NewClient(opts...)
 	if err != nil {
 		logrus.WithError(err).Fatal("Failed to create Franz-Go client")
 		return
 	}
-	c.client = client
 	defer client.Close()
 
+	// Leaving the group on close revokes the partitions, which waits for the last poll
+	defer client.AllowRebalance()
+
+	// The consumer client also publishes records that failed processing
+	processor := newMessageProcessor(c.handler, c.config, "franz", &franzRecordPublisher{client: client})
+	defer processor.close()
+
+	committer := newFranzCommitter(client, c.config)
+	defer committer.flush(context.Background())
+
+	// Pausing the topics pauses every partition assigned now or later
+	flow := newFlowController(c.config, "franz", sub.GroupID,
+		func() { client.PauseFetchTopics(sub.Topics...) },
+		func() { client.ResumeFetchTopics(sub.Topics...) },
+	)
+	defer flow.close()
+
+	// Workers must be done before the final commit
+	dispatcher := newDispatcher(processor, c.config, flow)
+	defer dispatcher.close()
+
+	// Callbacks only run while polling, which starts below
+	rebalance.dispatcher = dispatcher
+	rebalance.committer = committer
+
+	c.lag.register(sub.GroupID, func(ctx context.Context) ([]PartitionLag, error) {
+		return franzLag(ctx, client, sub.GroupID, rebalance.assignment.snapshot(), false)
+	})
+	defer c.lag.unregister(sub.GroupID)
+
+	c.groups.track(sub.GroupID, func() bool { return franzJoined(client) })
+	defer c.groups.track(sub.GroupID, nil)
+
 	logrus.WithFields(logrus.Fields{
 		"bootstrap_servers": c.config.BootstrapServers,
-		"group_id":          c.config.GroupID,
-		"topics":            c.config.Topics,
+		"group_id":          sub.GroupID,
+		"topics":            sub.Topics,
 	}).Info("Franz-Go Kafka consumer started and waiting for messages")
 
 	for {
@@ -76,47 +147,379 @@ func (c *FranzKafkaConsumer) consume(ctx context.Context) {
 			logrus.Info("Context canceled, stopping consumer")
 			return
 		default:
-			startTime := time.Now()
+			// The records of the previous poll were dispatched, let a pending rebalance proceed
+			client.AllowRebalance()
 
-			fetches := client.PollFetches(ctx)
+			if dispatcher.batching() {
+				if !c.consumeBatch(ctx, client, dispatcher, committer) {
+					return
+				}
+				continue
+			}
+
+			fetches := committer.poll(ctx, 0)
 			if fetches.IsClientClosed() {
 				return
 			}
 
-			if errs := fetches.Errors(); len(errs) > 0 {
-				for _, err := range errs {
-					logrus.WithError(err.Err).Error("Kafka consumer error")
-				}
+			if !logFetchErrors(fetches) {
 				continue
 			}
 
-			// Iterate over records
-			fetches.EachRecord(func(record *kgo.Record) {
-				messageID := uuid.New().String()
-
-				// Process the message using the domain service
-				description := "Note-" + messageID
-				_, err := c.orderService.CreateOrder(description, 1)
-				if err != nil {
-					logrus.WithError(err).Error("Error creating order")
+			// Iterate over records, stopping when the consumer is shutting down
+			for iter := fetches.RecordIter(); !iter.Done(); {
+				record := iter.Next()
+				err := dispatcher.dispatch(ctx, messageFromFranz(record), func() {
+					committer.handled(ctx, record)
+				})
+				if err != nil && ctx.Err() != nil {
+					break
 				}
+			}
+		}
+	}
+}
+
+// consumeBatch polls up to BatchSize records, waiting at most BatchTimeout for the
+// batch to fill once the first record arrived, and handles them as one batch.
+// It returns false once the client is closed.
+func (c *FranzKafkaConsumer) consumeBatch(ctx context.Context, client *kgo.Client, dispatcher *dispatcher, committer *franzCommitter) bool {
+	size := c.config.BatchSize
+
+	fetches := committer.poll(ctx, size)
+	if fetches.IsClientClosed() {
+		return false
+	}
+	if !logFetchErrors(fetches) {
+		return true
+	}
+
+	records := fetches.Records()
+	if len(records) == 0 {
+		return true
+	}
+
+	// Keep polling until the batch is full or the timeout expired
+	pollCtx, cancel := context.WithTimeout(ctx, c.config.batchTimeout())
+	defer cancel()
 
-				// Calculate processing time in milliseconds
-				processingTimeMs := time.Since(startTime).Milliseconds()
-
-				logrus.WithFields(logrus.Fields{
-					"message_id":         messageID,
-					"processing_time_ms": processingTimeMs,
-					"topic":              record.Topic,
-					"partition":          record.Partition,
-					"offset":             record.Offset,
-				}).Info("Message processed")
-			})
+	for len(records) < size {
+		fetches := client.PollRecords(pollCtx, size-len(records))
+		if fetches.IsClientClosed() {
+			return false
+		}
+		records = append(records, fetches.Records()...)
+		if pollCtx.Err() != nil {
+			break
 		}
+		logFetchErrors(fetches)
+	}
+
+	msgs := make([]*Message, 0, len(records))
+	for _, record := range records {
+		msgs = append(msgs, messageFromFranz(record))
+	}
+
+	handled, _ := dispatcher.dispatchBatch(ctx, msgs)
+	if handled > 0 {
+		committer.handled(ctx, records[:handled]...)
+	}
+
+	return true
+}
+
+// logFetchErrors logs the errors of a poll and reports whether it was successful
+func logFetchErrors(fetches kgo.Fetches) bool {
+	errs := fetches.Errors()
+	for _, err := range errs {
+		logrus.WithError(err.Err).Error("Kafka consumer error")
 	}
+	return len(errs) == 0
 }
 
 // Wait waits for all consumer goroutines to finish
 func (c *FranzKafkaConsumer) Wait() {
 	c.wg.Wait()
 }
+
+// Lag returns the lag of the partitions assigned to the consumer's clients
+func (c *FranzKafkaConsumer) Lag(ctx context.Context) ([]PartitionLag, error) {
+	return c.lag.lag(ctx)
+}
+
+// Groups reports whether the consumer's clients are members of their groups
+func (c *FranzKafkaConsumer) Groups() map[string]bool {
+	return c.groups.groups()
+}
+
+// franzJoined reports whether a client is a member of its group, the generation is -1 outside it
+func franzJoined(client *kgo.Client) bool {
+	_, generation := client.GroupMetadata()
+	return generation >= 0
+}
+
+// franzBalancer returns the group balancer for the assignor, or false for the client default
+func franzBalancer(assignor Assignor) (kgo.GroupBalancer, bool) {
+	switch assignor {
+	case AssignorRange:
+		return kgo.RangeBalancer(), true
+	case AssignorRoundRobin:
+		return kgo.RoundRobinBalancer(), true
+	case AssignorCooperativeSticky:
+		return kgo.CooperativeStickyBalancer(), true
+	case "":
+		return nil, false
+	default:
+		logrus.WithField("assignor", assignor).Warn("Unknown assignor, using the client default")
+		return nil, false
+	}
+}
+
+// franzRebalanceHandler reacts to partition assignment changes of a Franz-Go consumer
+type franzRebalanceHandler struct {
+	groupID    string
+	assignment *assignedPartitions
+	dispatcher *dispatcher
+	committer  *franzCommitter
+}
+
+// assigned logs newly assigned partitions
+func (h *franzRebalanceHandler) assigned(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	logPartitions("franz", h.groupID, "assigned", assigned)
+	h.assignment.add(assigned)
+}
+
+// revoked waits for in-flight records and commits their offsets before the partitions move
+func (h *franzRebalanceHandler) revoked(ctx context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	logPartitions("franz", h.groupID, "revoked", revoked)
+	h.assignment.remove(revoked)
+
+	h.dispatcher.drain()
+	h.committer.flush(ctx)
+}
+
+// lost drops in-flight state for partitions that were lost without a chance to commit
+func (h *franzRebalanceHandler) lost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	logPartitions("franz", h.groupID, "lost", lost)
+	h.assignment.remove(lost)
+
+	h.dispatcher.drain()
+}
+
+// franzLag compares the offsets committed by the client's group with the log start and end
+// offsets of the assigned partitions. Read committed consumers are compared with the last
+// stable offset.
+func franzLag(ctx context.Context, client *kgo.Client, groupID string, assigned map[string][]int32, readCommitted bool) ([]PartitionLag, error) {
+	if len(assigned) == 0 {
+		return nil, nil
+	}
+
+	logStarts, err := franzListOffsets(ctx, client, assigned, -2, false) // Earliest offset
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets for group %s: %w", groupID, err)
+	}
+	ends, err := franzListOffsets(ctx, client, assigned, -1, readCommitted) // Latest offset
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets for group %s: %w", groupID, err)
+	}
+
+	committed := client.CommittedOffsets()
+
+	var lags []PartitionLag
+	for topic, partitions := range ends {
+		for partition, end := range partitions {
+			committedOffset := int64(-1)
+			if offset, ok := committed[topic][partition]; ok {
+				committedOffset = offset.Offset
+			}
+
+			lags = append(lags, newPartitionLag(groupID, topic, partition, committedOffset, logStarts[topic][partition], end))
+		}
+	}
+
+	return lags, nil
+}
+
+// franzListOffsets lists the offsets of the partitions at a ListOffsets timestamp, -2 for the
+// log start and -1 for the end, which is the last stable offset for read committed consumers
+func franzListOffsets(ctx context.Context, client *kgo.Client, assigned map[string][]int32, timestamp int64, readCommitted bool) (map[string]map[int32]int64, error) {
+	req := kmsg.NewPtrListOffsetsRequest()
+	req.ReplicaID = -1
+	if readCommitted {
+		req.IsolationLevel = 1
+	}
+	for topic, partitions := range assigned {
+		reqTopic := kmsg.NewListOffsetsRequestTopic()
+		reqTopic.Topic = topic
+		for _, partition := range partitions {
+			reqPartition := kmsg.NewListOffsetsRequestTopicPartition()
+			reqPartition.Partition = partition
+			reqPartition.Timestamp = timestamp
+			reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
+		}
+		req.Topics = append(req.Topics, reqTopic)
+	}
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[string]map[int32]int64, len(resp.Topics))
+	for _, topic := range resp.Topics {
+		offsets[topic.Topic] = make(map[int32]int64, len(topic.Partitions))
+		for _, partition := range topic.Partitions {
+			if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
+				return nil, fmt.Errorf("failed to list offsets of %s/%d: %w", topic.Topic, partition.Partition, err)
+			}
+			offsets[topic.Topic][partition.Partition] = partition.Offset
+		}
+	}
+
+	return offsets, nil
+}
+
+// messageFromFranz converts a Franz-Go record into a client-agnostic message
+func messageFromFranz(record *kgo.Record) *Message {
+	headers := make([]Header, 0, len(record.Headers))
+	for _, h := range record.Headers {
+		headers = append(headers, Header{Key: h.Key, Value: h.Value})
+	}
+
+	return &Message{
+		Key:       record.Key,
+		Value:     record.Value,
+		Headers:   headers,
+		Topic:     record.Topic,
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Timestamp: record.Timestamp,
+	}
+}
+
+// franzRecordPublisher publishes records through an existing Franz-Go client
+type franzRecordPublisher struct {
+	client *kgo.Client
+}
+
+// publish synchronously writes a record to the given topic
+func (p *franzRecordPublisher) publish(ctx context.Context, topic string, key, value []byte, headers []Header) error {
+	record := &kgo.Record{
+		Topic:   topic,
+		Key:     key,
+		Value:   value,
+		Headers: toFranzHeaders(headers),
+	}
+	return p.client.ProduceSync(ctx, record).FirstErr()
+}
+
+// close is a no-op, the client is owned by the consumer
+func (p *franzRecordPublisher) close() {}
+
+// franzCommitter commits the offsets of handled records according to the commit mode.
+// It is safe for concurrent use by the consume loop and the workers.
+type franzCommitter struct {
+	mu      sync.Mutex
+	client  *kgo.Client
+	mode    CommitMode
+	batch   *commitBatch
+	pending []*kgo.Record
+}
+
+// newFranzCommitter creates a committer for the configured commit mode
+func newFranzCommitter(client *kgo.Client, config *ConsumerConfig) *franzCommitter {
+	return &franzCommitter{
+		client: client,
+		mode:   config.commitMode(),
+		batch:  newCommitBatch(config),
+	}
+}
+
+// poll fetches up to maxRecords records (no limit if not positive), waking up in
+// manual batch mode to commit on the interval while idle
+func (c *franzCommitter) poll(ctx context.Context, maxRecords int) kgo.Fetches {
+	if c.mode != CommitModeManualBatch {
+		return c.client.PollRecords(ctx, maxRecords)
+	}
+
+	pollCtx, cancel := context.WithTimeout(ctx, c.batch.interval)
+	defer cancel()
+
+	fetches := c.client.PollRecords(pollCtx, maxRecords)
+
+	c.mu.Lock()
+	if c.batch.due() {
+		c.flushLocked(ctx)
+	}
+	c.mu.Unlock()
+
+	// An expired poll timeout only means there was nothing to fetch
+	if pollCtx.Err() != nil && ctx.Err() == nil {
+		return kgo.Fetches{}
+	}
+	return fetches
+}
+
+// handled records that the records were handled and commits according to the mode
+func (c *franzCommitter) handled(ctx context.Context, records ...*kgo.Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.mode {
+	case CommitModeAuto:
+		c.client.MarkCommitRecords(records...)
+	case CommitModeAfterSuccess:
+		c.commit(ctx, records...)
+	case CommitModeManualBatch:
+		c.pending = append(c.pending, records...)
+		if c.batch.add(len(records)) {
+			c.flushLocked(ctx)
+		}
+	}
+}
+
+// flush commits the offsets of all handled records not committed yet
+func (c *franzCommitter) flush(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flushLocked(ctx)
+}
+
+// flushLocked is flush for callers already holding the lock
+func (c *franzCommitter) flushLocked(ctx context.Context) {
+	// Revoking partitions replaces the client's own final commit of the marked records
+	if c.mode == CommitModeAuto {
+		c.commitMarked(ctx)
+		return
+	}
+
+	if len(c.pending) == 0 {
+		return
+	}
+
+	c.commit(ctx, c.pending...)
+	c.pending = c.pending[:0]
+	c.batch.reset()
+}
+
+// commit synchronously commits the offsets following the given records
+func (c *franzCommitter) commit(ctx context.Context, records ...*kgo.Record) {
+	ctx, cancel := commitContext(ctx)
+	defer cancel()
+
+	if err := c.client.CommitRecords(ctx, records...); err != nil {
+		// The records will be redelivered, which at-least-once processing tolerates
+		logrus.WithError(err).Error("Failed to commit Franz-Go offsets")
+	}
+}
+
+// commitMarked synchronously commits the offsets of the records marked in auto mode
+func (c *franzCommitter) commitMarked(ctx context.Context) {
+	ctx, cancel := commitContext(ctx)
+	defer cancel()
+
+	if err := c.client.CommitMarkedOffsets(ctx); err != nil {
+		logrus.WithError(err).Error("Failed to commit marked Franz-Go offsets")
+	}
+}
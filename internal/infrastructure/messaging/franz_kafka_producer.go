@@ -3,9 +3,15 @@ package messaging
 import (
 	"context"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kerr"
 	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+	"goEvents/internal/correlation"
+	"goEvents/internal/infrastructure/messaging/event"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -15,25 +21,18 @@ type FranzKafkaProducer struct {
 	client      *kgo.Client
 	mutex       sync.Mutex
 	initialized bool
-	opts        []kgo.Opt
+	config      *ProducerConfig
 }
 
 // NewFranzKafkaProducer creates a new Kafka producer using Franz-Go library
-func NewFranzKafkaProducer(bootstrapServers string) *FranzKafkaProducer {
-	if bootstrapServers == "" {
-		bootstrapServers = "localhost:9092"
-	}
-
-	// Create producer configuration
-	opts := []kgo.Opt{
-		kgo.SeedBrokers(bootstrapServers),
-		kgo.ProducerBatchMaxBytes(1000000),
-		kgo.RequiredAcks(kgo.AllISRAcks()),
+func NewFranzKafkaProducer(config *ProducerConfig) *FranzKafkaProducer {
+	if config == nil {
+		logrus.Fatal("Kafka configuration must be provided")
 	}
 
 	return &FranzKafkaProducer{
 		initialized: false,
-		opts:        opts,
+		config:      config,
 	}
 }
 
@@ -46,48 +45,94 @@ func (p *FranzKafkaProducer) Initialize() error {
 		return nil
 	}
 
-	var err error
-	p.client, err = kgo.NewClient(p.opts...)
+	opts, err := franzProducerOpts(p.config)
+	if err != nil {
+		return err
+	}
+
+	p.client, err = kgo.NewClient(opts...)
 	if err != nil {
 		return fmt.Errorf("failed to create Franz-Go Kafka producer: %w", err)
 	}
 
-	logrus.Info("Franz-Go Kafka producer initialized")
+	logrus.WithFields(logrus.Fields{
+		"bootstrap_servers": p.config.BootstrapServers,
+		"topic":             p.config.Topic,
+	}).Info("Franz-Go Kafka producer initialized")
 
 	p.initialized = true
 	return nil
 }
 
-// PublishOrder publishes order messages to Kafka
-func (p *FranzKafkaProducer) PublishOrder(orderID string) error {
+// PublishOrder publishes an order event and waits until the brokers acknowledged it
+func (p *FranzKafkaProducer) PublishOrder(ctx context.Context, evt *event.OrderEvent) (err error) {
 	if err := p.Initialize(); err != nil {
 		return err
 	}
 
+	value, err := event.Encode(evt)
+	if err != nil {
+		return err
+	}
+
 	startTime := time.Now()
-	topic := "orders"
-
-	// In a real-world scenario, you would likely not send 100,000 messages in a loop
-	// This is just to maintain the same behavior as the other implementations
-	for i := 0; i < 100000; i++ {
-		record := &kgo.Record{
-			Topic: topic,
-			Key:   []byte(uuid.New().String()),
-			Value: []byte(orderID),
-		}
+	topic := p.config.Topic
 
-		// Send the message
-		if err := p.client.ProduceSync(context.Background(), record).FirstErr(); err != nil {
-			logrus.WithError(err).Error("Failed to send message with Franz-Go")
-			return err
-		}
+	ctx, span := startPublishSpan(ctx, topic)
+	defer func() { endSpan(span, err) }()
+
+	// Every record carries the correlation ID and the trace context of the span
+	record := &kgo.Record{
+		Topic:   topic,
+		Key:     evt.Key(),
+		Value:   value,
+		Headers: toFranzHeaders(orderEventHeaders(ctx, evt)),
 	}
 
-	processingTimeMs := time.Since(startTime).Milliseconds()
-	logrus.WithFields(logrus.Fields{
-		"order_id":           orderID,
-		"processing_time_ms": processingTimeMs,
-	}).Info("Completed sending messages with Franz-Go")
+	err = p.client.ProduceSync(ctx, record).FirstErr()
+	observeProduced("franz", topic, time.Since(startTime), err)
+	if err != nil {
+		correlation.Logger(ctx).WithError(err).Error("Failed to send message with Franz-Go")
+		return err
+	}
+
+	correlation.Logger(ctx).WithFields(logrus.Fields{
+		"event_id":   evt.EventID,
+		"event_type": evt.EventType,
+		"partition":  record.Partition,
+		"offset":     record.Offset,
+	}).Debug("Message sent with Franz-Go")
+
+	return nil
+}
+
+// Ping fetches the metadata of the configured topic with the producer's client
+func (p *FranzKafkaProducer) Ping(ctx context.Context) error {
+	p.mutex.Lock()
+	client := p.client
+	p.mutex.Unlock()
+
+	if client == nil {
+		return ErrProducerNotInitialized
+	}
+
+	req := kmsg.NewPtrMetadataRequest()
+	topic := kmsg.NewMetadataRequestTopic()
+	topic.Topic = kmsg.StringPtr(p.config.Topic)
+	req.Topics = append(req.Topics, topic)
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to fetch metadata: %w", err)
+	}
+	if len(resp.Brokers) == 0 {
+		return errNoBrokers
+	}
+	for _, t := range resp.Topics {
+		if err := kerr.ErrorForCode(t.ErrorCode); err != nil {
+			return fmt.Errorf("topic %s: %w", p.config.Topic, err)
+		}
+	}
 
 	return nil
 }
@@ -103,9 +148,10 @@ func (p *FranzKafkaProducer) Shutdown(ctx context.Context) {
 
 	// Create a channel to signal when shutdown is complete
 	done := make(chan bool)
+	client := p.client
 
 	go func() {
-		p.client.Close()
+		client.Close()
 		logrus.Info("Franz-Go producer closed successfully")
 		close(done)
 	}()
@@ -121,3 +167,101 @@ func (p *FranzKafkaProducer) Shutdown(ctx context.Context) {
 	p.initialized = false
 	p.client = nil
 }
+
+// franzProducerOpts maps the producer configuration into Franz-Go client options
+func franzProducerOpts(config *ProducerConfig) ([]kgo.Opt, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(config.brokers()...),
+		kgo.ProducerLinger(config.Linger),
+		kgo.ProducerBatchMaxBytes(int32(config.batchMaxBytes())),
+		kgo.RecordRetries(config.Retries),
+	}
+
+	switch config.acks() {
+	case AcksAll:
+		opts = append(opts, kgo.RequiredAcks(kgo.AllISRAcks()))
+	case AcksLeader:
+		opts = append(opts, kgo.RequiredAcks(kgo.LeaderAck()))
+	case AcksNone:
+		opts = append(opts, kgo.RequiredAcks(kgo.NoAck()))
+	}
+
+	// Franz-Go writes idempotently unless told otherwise
+	if !config.Idempotent {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+
+	switch config.compression() {
+	case CompressionNone:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.NoCompression()))
+	case CompressionGzip:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.GzipCompression()))
+	case CompressionSnappy:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.SnappyCompression()))
+	case CompressionLz4:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	case CompressionZstd:
+		opts = append(opts, kgo.ProducerBatchCompression(kgo.ZstdCompression()))
+	}
+
+	switch config.partitioner() {
+	case PartitionerMurmur2:
+		// A nil hasher hashes keys with murmur2 like the Java client
+		opts = append(opts, kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)))
+	case PartitionerRandom:
+		opts = append(opts, kgo.RecordPartitioner(kgo.BasicConsistentPartitioner(func(string) func(*kgo.Record, int) int {
+			return func(_ *kgo.Record, n int) int { return rand.Intn(n) }
+		})))
+	}
+
+	securityOpts, err := franzSecurityOpts(config.Security)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(opts, securityOpts...), nil
+}
+
+// franzSecurityOpts maps the security configuration into Franz-Go client options
+func franzSecurityOpts(security SecurityConfig) ([]kgo.Opt, error) {
+	if err := security.Validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := security.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []kgo.Opt
+	if tlsConfig != nil {
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+
+	switch security.SASL.Mechanism {
+	case SASLMechanismPlain:
+		auth := plain.Auth{User: security.SASL.Username, Pass: security.SASL.Password}
+		opts = append(opts, kgo.SASL(auth.AsMechanism()))
+	case SASLMechanismScramSHA256:
+		auth := scram.Auth{User: security.SASL.Username, Pass: security.SASL.Password}
+		opts = append(opts, kgo.SASL(auth.AsSha256Mechanism()))
+	case SASLMechanismScramSHA512:
+		auth := scram.Auth{User: security.SASL.Username, Pass: security.SASL.Password}
+		opts = append(opts, kgo.SASL(auth.AsSha512Mechanism()))
+	}
+
+	return opts, nil
+}
+
+// toFranzHeaders converts client-agnostic headers into Franz-Go record headers
+func toFranzHeaders(headers []Header) []kgo.RecordHeader {
+	result := make([]kgo.RecordHeader, 0, len(headers))
+	for _, h := range headers {
+		result = append(result, kgo.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return result
+}
@@ -0,0 +1,211 @@
+package messaging
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"sync"
+	"time"
+)
+
+// FranzTransactionalConsumer implements the MessageConsumer interface with exactly-once
+// semantics using a Franz-Go GroupTransactSession. Each polled batch is transformed and
+// its output records and consumed offsets are committed in a single transaction.
+type FranzTransactionalConsumer struct {
+	handler TransformHandler
+	config  *ConsumerConfig
+	wg      sync.WaitGroup
+	lag     lagRegistry
+	groups  groupMembership
+}
+
+// NewFranzTransactionalConsumer creates a new transactional consumer with the given handler
+func NewFranzTransactionalConsumer(handler TransformHandler, config *ConsumerConfig) *FranzTransactionalConsumer {
+	if config == nil {
+		logrus.Fatal("Kafka configuration must be provided")
+	}
+	if config.TransactionalID == "" {
+		logrus.WithError(ErrMissingTransactionalID).Fatal("Invalid Kafka configuration")
+	}
+	if config.DeadLetterTopic == "" {
+		logrus.WithError(ErrMissingDeadLetterTopic).Fatal("Invalid Kafka configuration")
+	}
+	if err := config.Security.Validate(); err != nil {
+		logrus.WithError(err).Fatal("Invalid Kafka configuration")
+	}
+
+	return &FranzTransactionalConsumer{
+		handler: handler,
+		config:  config,
+	}
+}
+
+// Start begins consuming messages in a goroutine
+func (c *FranzTransactionalConsumer) Start(ctx context.Context) {
+	c.groups.expect(c.config.GroupID)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		// A session whose transactional state failed is replaced by a new one
+		backoff := time.Second
+		for ctx.Err() == nil {
+			if err := c.consume(ctx); err != nil {
+				logrus.WithError(err).Error("Franz-Go transactional session failed, restarting")
+				sleepContext(ctx, backoff)
+				backoff = nextBackoff(backoff)
+				continue
+			}
+			backoff = time.Second
+		}
+	}()
+}
+
+// Wait waits for all consumer goroutines to finish
+func (c *FranzTransactionalConsumer) Wait() {
+	c.wg.Wait()
+}
+
+// Lag returns the lag of the partitions assigned to the transactional session
+func (c *FranzTransactionalConsumer) Lag(ctx context.Context) ([]PartitionLag, error) {
+	return c.lag.lag(ctx)
+}
+
+// Groups reports whether the transactional session is a member of its group
+func (c *FranzTransactionalConsumer) Groups() map[string]bool {
+	return c.groups.groups()
+}
+
+// consume runs one transactional session until the context is canceled or the session fails
+func (c *FranzTransactionalConsumer) consume(ctx context.Context) error {
+	assignment := newAssignedPartitions()
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(c.config.brokers()...),
+		kgo.ConsumerGroup(c.config.GroupID),
+		kgo.ConsumeTopics(c.config.Topics...),
+		kgo.TransactionalID(c.config.TransactionalID),
+		// Only read records from committed transactions
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.RequireStableFetchOffsets(),
+		kgo.OnPartitionsAssigned(func(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+			assignment.add(assigned)
+		}),
+		kgo.OnPartitionsRevoked(func(_ context.Context, _ *kgo.Client, revoked map[string][]int32) {
+			assignment.remove(revoked)
+		}),
+		kgo.OnPartitionsLost(func(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+			assignment.remove(lost)
+		}),
+	}
+
+	// Set initial offset based on configuration
+	if c.config.AutoOffsetReset == "earliest" {
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()))
+	} else {
+		opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()))
+	}
+
+	securityOpts, err := franzSecurityOpts(c.config.Security)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, securityOpts...)
+
+	session, err := kgo.NewGroupTransactSession(opts...)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	c.lag.register(c.config.GroupID, func(ctx context.Context) ([]PartitionLag, error) {
+		return franzLag(ctx, session.Client(), c.config.GroupID, assignment.snapshot(), true)
+	})
+	defer c.lag.unregister(c.config.GroupID)
+
+	c.groups.track(c.config.GroupID, func() bool { return franzJoined(session.Client()) })
+	defer c.groups.track(c.config.GroupID, nil)
+
+	logrus.WithFields(logrus.Fields{
+		"bootstrap_servers": c.config.BootstrapServers,
+		"group_id":          c.config.GroupID,
+		"topics":            c.config.Topics,
+		"transactional_id":  c.config.TransactionalID,
+	}).Info("Franz-Go transactional consumer started and waiting for messages")
+
+	backoff := 100 * time.Millisecond
+	for {
+		fetches := session.PollFetches(ctx)
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			return nil
+		}
+
+		if errs := fetches.Errors(); len(errs) > 0 {
+			for _, err := range errs {
+				logrus.WithError(err.Err).Error("Kafka consumer error")
+			}
+			continue
+		}
+
+		if fetches.NumRecords() == 0 {
+			continue
+		}
+
+		committed, err := c.processBatch(ctx, session, fetches)
+		if err != nil {
+			return err
+		}
+
+		if committed {
+			backoff = 100 * time.Millisecond
+			continue
+		}
+
+		// The session rewound to the last committed offsets, slow down before reprocessing
+		logrus.WithField("backoff", backoff.String()).Warn("Transaction aborted, batch will be reprocessed")
+		sleepContext(ctx, backoff)
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// processBatch transforms a polled batch inside a transaction and ends it. It returns
+// whether the transaction committed, and an error only if the session is unusable.
+func (c *FranzTransactionalConsumer) processBatch(ctx context.Context, session *kgo.GroupTransactSession, fetches kgo.Fetches) (bool, error) {
+	if err := session.Begin(); err != nil {
+		return false, err
+	}
+
+	for iter := fetches.RecordIter(); !iter.Done(); {
+		record := iter.Next()
+
+		outputs := transform(ctx, "franz", c.handler, c.config, messageFromFranz(record))
+		for _, out := range outputs {
+			session.Produce(ctx, toFranzRecord(out), func(_ *kgo.Record, err error) {
+				if err != nil {
+					logrus.WithError(err).Error("Failed to produce output record, aborting transaction")
+				}
+			})
+		}
+	}
+
+	// Ending flushes the outputs and aborts instead of committing if any failed
+	committed, err := session.End(ctx, kgo.TryCommit)
+	if err != nil {
+		return false, err
+	}
+
+	if committed {
+		logrus.WithField("records", fetches.NumRecords()).Debug("Transaction committed")
+	}
+
+	return committed, nil
+}
+
+// toFranzRecord converts a client-agnostic output message into a Franz-Go record
+func toFranzRecord(msg *Message) *kgo.Record {
+	return &kgo.Record{
+		Topic:   msg.Topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: toFranzHeaders(msg.Headers),
+	}
+}
@@ -0,0 +1,19 @@
+package messaging
+
+import (
+	"context"
+)
+
+// MessageHandler processes consumed messages. Consumers call it for every record,
+// and a returned error sends the record to the retry or dead-letter topics.
+type MessageHandler interface {
+	Handle(ctx context.Context, msg *Message) error
+}
+
+// MessageHandlerFunc adapts a function to the MessageHandler interface
+type MessageHandlerFunc func(ctx context.Context, msg *Message) error
+
+// Handle calls f(ctx, msg)
+func (f MessageHandlerFunc) Handle(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
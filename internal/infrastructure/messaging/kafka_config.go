@@ -1,9 +1,19 @@
 package messaging
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
 // ConsumerConfig holds common configuration for message consumers
 type ConsumerConfig struct {
 	// BootstrapServers is a comma-separated list of host:port addresses of brokers
 	BootstrapServers string
+	// Security configures TLS and SASL for every client of the consumer, including the
+	// producers of dead-letter, retry and transactional records
+	Security SecurityConfig
 	// GroupID is the consumer group identifier
 	GroupID string
 	// Topics is a list of topics to subscribe to
@@ -11,4 +21,273 @@ type ConsumerConfig struct {
 	// AutoOffsetReset defines where to start consuming if no offset is found
 	// Values: "earliest", "latest"
 	AutoOffsetReset string
+	// DeadLetterTopic receives records that failed processing, unchanged and with
+	// headers describing the failure. Leave empty to only log failures.
+	DeadLetterTopic string
+	// RetryTiers are tried in order before a failed record is dead-lettered.
+	// Each tier is consumed by its own client so waiting never blocks the main topics.
+	RetryTiers []RetryTier
+	// CommitMode controls when offsets are committed, defaults to CommitModeAuto
+	CommitMode CommitMode
+	// CommitBatchSize is the number of handled records per commit in CommitModeManualBatch
+	CommitBatchSize int
+	// CommitInterval is the longest handled offsets stay uncommitted in CommitModeManualBatch
+	CommitInterval time.Duration
+	// Workers is the number of records processed in parallel by each consumer client.
+	// Records with the same key always go to the same worker, so per-key ordering is
+	// kept. Zero or one processes records one at a time in the consume loop.
+	Workers int
+	// BatchSize enables batch consumption when greater than one and the handler is a
+	// BatchHandler: up to BatchSize records are gathered and handled in one call.
+	// Batches are handled in the consume loop, so Workers does not apply.
+	BatchSize int
+	// BatchTimeout is the longest a batch waits to fill up after its first record
+	BatchTimeout time.Duration
+	// Assignor selects the partition assignment strategy, empty keeps the client default
+	Assignor Assignor
+	// Backpressure pauses fetching while the handler falls behind, disabled by default
+	Backpressure BackpressureConfig
+	// TransactionalID is the transactional producer ID prefix used by the transactional
+	// consumers. It must be stable across restarts and unique per application instance.
+	TransactionalID string
+}
+
+// ErrInvalidConsumerConfig is returned when a ConsumerConfig is incomplete or inconsistent
+var ErrInvalidConsumerConfig = errors.New("invalid Kafka consumer configuration")
+
+// Validate checks that the configuration is complete and uses known values.
+// Whether the chosen client supports the requested features is checked by NewConsumer.
+func (c *ConsumerConfig) Validate() error {
+	if len(c.brokers()) == 0 || c.GroupID == "" || len(c.Topics) == 0 {
+		return fmt.Errorf("%w: BootstrapServers, GroupID and Topics are required", ErrInvalidConsumerConfig)
+	}
+
+	switch c.AutoOffsetReset {
+	case "earliest", "latest":
+	default:
+		return fmt.Errorf("%w: AutoOffsetReset must be %q or %q, got %q", ErrInvalidConsumerConfig, "earliest", "latest", c.AutoOffsetReset)
+	}
+
+	switch c.commitMode() {
+	case CommitModeAuto, CommitModeAfterSuccess, CommitModeManualBatch:
+	default:
+		return fmt.Errorf("%w: unsupported commit mode %q", ErrInvalidConsumerConfig, c.CommitMode)
+	}
+
+	switch c.Assignor {
+	case "", AssignorRange, AssignorRoundRobin, AssignorCooperativeSticky:
+	default:
+		return fmt.Errorf("%w: unsupported assignor %q", ErrInvalidConsumerConfig, c.Assignor)
+	}
+
+	for _, tier := range c.RetryTiers {
+		if tier.Topic == "" {
+			return fmt.Errorf("%w: retry tiers require a topic", ErrInvalidConsumerConfig)
+		}
+	}
+
+	return c.Security.Validate()
+}
+
+// brokers returns the broker addresses listed in BootstrapServers
+func (c *ConsumerConfig) brokers() []string {
+	return splitBrokers(c.BootstrapServers)
+}
+
+// ErrInvalidProducerConfig is returned when a ProducerConfig cannot be applied to a client
+var ErrInvalidProducerConfig = errors.New("invalid Kafka producer configuration")
+
+// Acks selects how many replicas must store a record before a produce request succeeds
+type Acks string
+
+const (
+	// AcksAll waits for all in-sync replicas, required for idempotent producers
+	AcksAll Acks = "all"
+	// AcksLeader only waits for the partition leader
+	AcksLeader Acks = "leader"
+	// AcksNone does not wait for any acknowledgement
+	AcksNone Acks = "none"
+)
+
+// Compression selects the codec used to compress record batches
+type Compression string
+
+const (
+	CompressionNone   Compression = "none"
+	CompressionGzip   Compression = "gzip"
+	CompressionSnappy Compression = "snappy"
+	CompressionLz4    Compression = "lz4"
+	CompressionZstd   Compression = "zstd"
+)
+
+// Partitioner selects the partition of each produced record
+type Partitioner string
+
+const (
+	// PartitionerMurmur2 hashes the key with murmur2 like the Java client, so records with
+	// the same key land on the same partition whichever client produced them. Records
+	// without a key are spread over all partitions.
+	PartitionerMurmur2 Partitioner = "murmur2"
+	// PartitionerRandom ignores the key and picks a random partition for each record
+	PartitionerRandom Partitioner = "random"
+)
+
+// ProducerConfig holds common configuration for message producers.
+// Empty Acks, Compression and Partitioner use AcksAll, CompressionNone and PartitionerMurmur2.
+type ProducerConfig struct {
+	// BootstrapServers is a comma-separated list of host:port addresses of brokers
+	BootstrapServers string
+	// Security configures TLS and SASL for the producer client
+	Security SecurityConfig
+	// Topic receives the published order events
+	Topic string
+	// Acks is the number of replicas acknowledging each produce request
+	Acks Acks
+	// Compression is the codec of produced batches
+	Compression Compression
+	// Linger is how long a batch waits for more records before it is sent, zero sends at once
+	Linger time.Duration
+	// BatchMaxBytes is the largest batch sent in one request, zero keeps 1MB
+	BatchMaxBytes int
+	// Idempotent makes the brokers drop duplicates created by retries. It requires
+	// AcksAll and at least one retry.
+	Idempotent bool
+	// Retries is how often a failed produce request is retried before the record fails
+	Retries int
+	// Partitioner selects the partition of each record
+	Partitioner Partitioner
+}
+
+// DefaultProducerConfig returns a configuration with reasonable defaults
+func DefaultProducerConfig() ProducerConfig {
+	return ProducerConfig{
+		BootstrapServers: "localhost:9092",
+		Topic:            "orders",
+		Acks:             AcksAll,
+		Compression:      CompressionNone,
+		BatchMaxBytes:    defaultBatchMaxBytes,
+		Idempotent:       true,
+		Retries:          5,
+		Partitioner:      PartitionerMurmur2,
+	}
+}
+
+// defaultBatchMaxBytes is the batch size limit used when BatchMaxBytes is zero
+const defaultBatchMaxBytes = 1000000
+
+// Validate checks that the configuration is complete and consistent
+func (c *ProducerConfig) Validate() error {
+	if len(c.brokers()) == 0 {
+		return fmt.Errorf("%w: BootstrapServers is required", ErrInvalidProducerConfig)
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("%w: Topic is required", ErrInvalidProducerConfig)
+	}
+
+	switch c.acks() {
+	case AcksAll, AcksLeader, AcksNone:
+	default:
+		return fmt.Errorf("%w: unsupported acks %q", ErrInvalidProducerConfig, c.Acks)
+	}
+
+	switch c.compression() {
+	case CompressionNone, CompressionGzip, CompressionSnappy, CompressionLz4, CompressionZstd:
+	default:
+		return fmt.Errorf("%w: unsupported compression %q", ErrInvalidProducerConfig, c.Compression)
+	}
+
+	switch c.partitioner() {
+	case PartitionerMurmur2, PartitionerRandom:
+	default:
+		return fmt.Errorf("%w: unsupported partitioner %q", ErrInvalidProducerConfig, c.Partitioner)
+	}
+
+	if c.Linger < 0 || c.BatchMaxBytes < 0 || c.Retries < 0 {
+		return fmt.Errorf("%w: Linger, BatchMaxBytes and Retries must not be negative", ErrInvalidProducerConfig)
+	}
+
+	if c.Idempotent && (c.acks() != AcksAll || c.Retries == 0) {
+		return fmt.Errorf("%w: an idempotent producer requires acks %q and at least one retry", ErrInvalidProducerConfig, AcksAll)
+	}
+
+	return c.Security.Validate()
+}
+
+// acks returns the configured acks, AcksAll when empty
+func (c *ProducerConfig) acks() Acks {
+	if c.Acks == "" {
+		return AcksAll
+	}
+	return c.Acks
+}
+
+// compression returns the configured codec, CompressionNone when empty
+func (c *ProducerConfig) compression() Compression {
+	if c.Compression == "" {
+		return CompressionNone
+	}
+	return c.Compression
+}
+
+// partitioner returns the configured partitioner, PartitionerMurmur2 when empty
+func (c *ProducerConfig) partitioner() Partitioner {
+	if c.Partitioner == "" {
+		return PartitionerMurmur2
+	}
+	return c.Partitioner
+}
+
+// batchMaxBytes returns the configured batch size limit, 1MB when zero
+func (c *ProducerConfig) batchMaxBytes() int {
+	if c.BatchMaxBytes == 0 {
+		return defaultBatchMaxBytes
+	}
+	return c.BatchMaxBytes
+}
+
+// subscription is a set of topics consumed by one client in one consumer group
+type subscription struct {
+	GroupID string
+	Topics  []string
+	// MaxDelay is the longest a record may be held before processing, zero for main topics
+	MaxDelay time.Duration
+}
+
+// subscriptions returns the main subscription followed by one per retry tier
+func (c *ConsumerConfig) subscriptions() []subscription {
+	subs := []subscription{{
+		GroupID: c.GroupID,
+		Topics:  c.Topics,
+	}}
+
+	for _, tier := range c.RetryTiers {
+		subs = append(subs, subscription{
+			GroupID:  c.GroupID + "." + tier.Topic,
+			Topics:   []string{tier.Topic},
+			MaxDelay: tier.Delay,
+		})
+	}
+
+	return subs
+}
+
+// republishes reports whether failed records are sent to another topic
+func (c *ConsumerConfig) republishes() bool {
+	return c.DeadLetterTopic != "" || len(c.RetryTiers) > 0
+}
+
+// brokers returns the broker addresses listed in BootstrapServers
+func (c *ProducerConfig) brokers() []string {
+	return splitBrokers(c.BootstrapServers)
+}
+
+// splitBrokers splits a comma-separated broker list, trimming spaces and dropping empty entries
+func splitBrokers(servers string) []string {
+	var brokers []string
+	for _, broker := range strings.Split(servers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
 }
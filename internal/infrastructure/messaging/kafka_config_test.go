@@ -0,0 +1,25 @@
+package messaging
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitBrokers(t *testing.T) {
+	tests := []struct {
+		servers string
+		want    []string
+	}{
+		{servers: "localhost:9092", want: []string{"localhost:9092"}},
+		{servers: "kafka-1:9092,kafka-2:9092", want: []string{"kafka-1:9092", "kafka-2:9092"}},
+		{servers: " kafka-1:9092 , kafka-2:9092 ,", want: []string{"kafka-1:9092", "kafka-2:9092"}},
+		{servers: " , ", want: nil},
+		{servers: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := splitBrokers(tt.servers); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitBrokers(%q) = %q, want %q", tt.servers, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// PartitionLag is how far a consumer group is behind on one partition
+type PartitionLag struct {
+	GroupID   string `json:"group_id"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// Committed is the next offset the group will consume, -1 if nothing was committed yet
+	Committed int64 `json:"committed"`
+	// LogStart is the offset of the oldest record retention kept in the partition
+	LogStart int64 `json:"log_start"`
+	// HighWatermark is the offset of the next record written to the partition
+	HighWatermark int64 `json:"high_watermark"`
+	// Lag is the number of records not committed yet. Without a committed offset, or with one
+	// retention already deleted, it counts the records from the log start offset.
+	Lag int64 `json:"lag"`
+}
+
+// newPartitionLag computes the lag of a partition from its committed, log start and high watermark offsets
+func newPartitionLag(groupID, topic string, partition int32, committed, logStart, highWatermark int64) PartitionLag {
+	return PartitionLag{
+		GroupID:       groupID,
+		Topic:         topic,
+		Partition:     partition,
+		Committed:     committed,
+		LogStart:      logStart,
+		HighWatermark: highWatermark,
+		Lag:           max(highWatermark-max(committed, logStart), 0),
+	}
+}
+
+// TotalLag sums the lag of the given partitions
+func TotalLag(lags []PartitionLag) int64 {
+	var total int64
+	for _, l := range lags {
+		total += l.Lag
+	}
+	return total
+}
+
+// assignedPartitions tracks the partitions currently assigned to a client
+type assignedPartitions struct {
+	mu         sync.Mutex
+	partitions map[string]map[int32]struct{}
+}
+
+// newAssignedPartitions creates an empty assignment
+func newAssignedPartitions() *assignedPartitions {
+	return &assignedPartitions{
+		partitions: make(map[string]map[int32]struct{}),
+	}
+}
+
+// add records newly assigned partitions
+func (a *assignedPartitions) add(assigned map[string][]int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for topic, partitions := range assigned {
+		if a.partitions[topic] == nil {
+			a.partitions[topic] = make(map[int32]struct{})
+		}
+		for _, partition := range partitions {
+			a.partitions[topic][partition] = struct{}{}
+		}
+	}
+}
+
+// remove forgets revoked or lost partitions
+func (a *assignedPartitions) remove(removed map[string][]int32) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for topic, partitions := range removed {
+		for _, partition := range partitions {
+			delete(a.partitions[topic], partition)
+		}
+		if len(a.partitions[topic]) == 0 {
+			delete(a.partitions, topic)
+		}
+	}
+}
+
+// snapshot returns the assigned partitions by topic
+func (a *assignedPartitions) snapshot() map[string][]int32 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	snapshot := make(map[string][]int32, len(a.partitions))
+	for topic, partitions := range a.partitions {
+		for partition := range partitions {
+			snapshot[topic] = append(snapshot[topic], partition)
+		}
+	}
+	return snapshot
+}
+
+// lagSource reports the lag of the partitions assigned to one running client
+type lagSource func(ctx context.Context) ([]PartitionLag, error)
+
+// lagRegistry collects the lag sources of the clients of a consumer, one per consumer group.
+// Sources are queried under the read lock, so unregister waits for running queries and a
+// client can be closed safely once it is unregistered.
+type lagRegistry struct {
+	mu      sync.RWMutex
+	sources map[string]lagSource
+}
+
+// register adds the lag source of a running client
+func (r *lagRegistry) register(groupID string, source lagSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sources == nil {
+		r.sources = make(map[string]lagSource)
+	}
+	r.sources[groupID] = source
+}
+
+// unregister removes the lag source of a client that stopped
+func (r *lagRegistry) unregister(groupID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sources, groupID)
+}
+
+// lag queries every running client and returns the lag sorted by group, topic and partition
+func (r *lagRegistry) lag(ctx context.Context) ([]PartitionLag, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var lags []PartitionLag
+	var errs []error
+	for _, source := range r.sources {
+		sourceLags, err := source(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lags = append(lags, sourceLags...)
+	}
+
+	sort.Slice(lags, func(i, j int) bool {
+		if lags[i].GroupID != lags[j].GroupID {
+			return lags[i].GroupID < lags[j].GroupID
+		}
+		if lags[i].Topic != lags[j].Topic {
+			return lags[i].Topic < lags[j].Topic
+		}
+		return lags[i].Partition < lags[j].Partition
+	})
+
+	return lags, errors.Join(errs...)
+}
@@ -0,0 +1,125 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewPartitionLag(t *testing.T) {
+	tests := []struct {
+		name      string
+		committed int64
+		logStart  int64
+		want      int64
+	}{
+		{name: "committed", committed: 70, logStart: 0, want: 30},
+		{name: "nothing committed", committed: -1, logStart: 0, want: 100},
+		{name: "nothing committed after retention", committed: -1, logStart: 60, want: 40},
+		{name: "committed offset deleted by retention", committed: 20, logStart: 60, want: 40},
+		{name: "caught up", committed: 100, logStart: 60, want: 0},
+		{name: "committed past the high watermark", committed: 120, logStart: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lag := newPartitionLag("group", "orders", 0, tt.committed, tt.logStart, 100)
+			if lag.Lag != tt.want {
+				t.Errorf("lag = %d, want %d", lag.Lag, tt.want)
+			}
+		})
+	}
+}
+
+// TestFranzLagCountsFromLogStart deletes the oldest records of a partition the group never
+// committed on and checks that only the records retention kept count as lag
+func TestFranzLagCountsFromLogStart(t *testing.T) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, "orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Close()
+
+	client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if err := client.ProduceSync(ctx, &kgo.Record{Topic: "orders", Value: []byte(strconv.Itoa(i))}).FirstErr(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Retention removes the records before offset 6
+	req := kmsg.NewPtrDeleteRecordsRequest()
+	topic := kmsg.NewDeleteRecordsRequestTopic()
+	topic.Topic = "orders"
+	partition := kmsg.NewDeleteRecordsRequestTopicPartition()
+	partition.Offset = 6
+	topic.Partitions = append(topic.Partitions, partition)
+	req.Topics = append(req.Topics, topic)
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kerr.ErrorForCode(resp.Topics[0].Partitions[0].ErrorCode); err != nil {
+		t.Fatal(err)
+	}
+
+	lags, err := franzLag(ctx, client, "lag-test", map[string][]int32{"orders": {0}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lags) != 1 {
+		t.Fatalf("got %d partition lags, want 1", len(lags))
+	}
+	if got := lags[0]; got.Committed != -1 || got.LogStart != 6 || got.HighWatermark != 10 || got.Lag != 4 {
+		t.Errorf("lag = %+v, want nothing committed, log start 6, high watermark 10 and lag 4", got)
+	}
+}
+
+// stalledSaramaClient is a Sarama client whose coordinator lookup hangs until released
+type stalledSaramaClient struct {
+	sarama.Client
+	release chan struct{}
+}
+
+func (c *stalledSaramaClient) Coordinator(string) (*sarama.Broker, error) {
+	<-c.release
+	return nil, sarama.ErrClosedClient
+}
+
+// TestSaramaLagStopsAtDeadline checks that a lag query against a broker that does not
+// answer returns once its context is done
+func TestSaramaLagStopsAtDeadline(t *testing.T) {
+	client := &stalledSaramaClient{release: make(chan struct{})}
+	defer close(client.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := saramaLag(ctx, client, "lag-test", map[string][]int32{"orders": {0}})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("saramaLag() error = %v, want the context deadline", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("saramaLag() did not return at the context deadline")
+	}
+}
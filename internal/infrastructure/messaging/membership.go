@@ -0,0 +1,41 @@
+package messaging
+
+import (
+	"sync"
+)
+
+// groupMembership tracks whether the clients of a consumer are members of their consumer
+// groups, one entry per group. A group is expected as soon as the consumer starts, so a
+// client still connecting is reported as not joined rather than missing.
+type groupMembership struct {
+	mu     sync.RWMutex
+	joined map[string]func() bool
+}
+
+// expect adds a group whose client has not joined yet
+func (m *groupMembership) expect(groupID string) {
+	m.track(groupID, nil)
+}
+
+// track sets how a running client reports its membership, nil while no client runs
+func (m *groupMembership) track(groupID string, joined func() bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.joined == nil {
+		m.joined = make(map[string]func() bool)
+	}
+	m.joined[groupID] = joined
+}
+
+// groups reports for every expected group whether its client is currently a member
+func (m *groupMembership) groups() map[string]bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := make(map[string]bool, len(m.joined))
+	for groupID, joined := range m.joined {
+		groups[groupID] = joined != nil && joined()
+	}
+	return groups
+}
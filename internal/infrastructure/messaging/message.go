@@ -0,0 +1,30 @@
+package messaging
+
+import "time"
+
+// Header is a client-agnostic Kafka record header
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a client-agnostic view of a consumed Kafka record
+type Message struct {
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Topic     string
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+}
+
+// Header returns the value of the last header with the given key
+func (m *Message) Header(key string) (string, bool) {
+	for i := len(m.Headers) - 1; i >= 0; i-- {
+		if m.Headers[i].Key == key {
+			return string(m.Headers[i].Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,107 @@
+package messaging
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"time"
+)
+
+// Prometheus metrics of the messaging layer, registered with the default registry
+var (
+	consumerPausesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goevents",
+		Subsystem: "consumer",
+		Name:      "pauses_total",
+		Help:      "Number of times a consumer paused fetching because of backpressure.",
+	}, []string{"client", "group", "reason"})
+
+	consumerPaused = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "goevents",
+		Subsystem: "consumer",
+		Name:      "paused",
+		Help:      "Whether a consumer has paused fetching because of backpressure (1) or not (0).",
+	}, []string{"client", "group"})
+)
+
+// Producer and consumer metrics, labeled by client library and topic
+var (
+	producerRecordsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goevents",
+		Subsystem: "producer",
+		Name:      "records_total",
+		Help:      "Number of records produced successfully.",
+	}, []string{"client", "topic"})
+
+	producerErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goevents",
+		Subsystem: "producer",
+		Name:      "errors_total",
+		Help:      "Number of records that could not be produced.",
+	}, []string{"client", "topic"})
+
+	producerLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "goevents",
+		Subsystem: "producer",
+		Name:      "latency_seconds",
+		Help:      "Time from producing a record until the broker acknowledged it.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"client", "topic"})
+
+	consumerMessagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goevents",
+		Subsystem: "consumer",
+		Name:      "messages_total",
+		Help:      "Number of messages passed to the handler, including failed ones.",
+	}, []string{"client", "topic"})
+
+	consumerErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "goevents",
+		Subsystem: "consumer",
+		Name:      "errors_total",
+		Help:      "Number of messages the handler failed on, whether they were retried, dead-lettered or not.",
+	}, []string{"client", "topic"})
+
+	consumerHandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "goevents",
+		Subsystem: "consumer",
+		Name:      "handler_duration_seconds",
+		Help:      "Time the handler took for one message.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"client", "topic"})
+
+	consumerBatchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "goevents",
+		Subsystem: "consumer",
+		Name:      "batch_duration_seconds",
+		Help:      "Time the batch handler took for one batch.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"client", "topic"})
+)
+
+// observeProduced records the outcome of producing one record
+func observeProduced(client, topic string, latency time.Duration, err error) {
+	if err != nil {
+		producerErrorsTotal.WithLabelValues(client, topic).Inc()
+		return
+	}
+	producerRecordsTotal.WithLabelValues(client, topic).Inc()
+	producerLatency.WithLabelValues(client, topic).Observe(latency.Seconds())
+}
+
+// observeHandled records the outcome of handling one message
+func observeHandled(client, topic string, duration time.Duration, err error) {
+	consumerMessagesTotal.WithLabelValues(client, topic).Inc()
+	consumerHandlerDuration.WithLabelValues(client, topic).Observe(duration.Seconds())
+	if err != nil {
+		consumerErrorsTotal.WithLabelValues(client, topic).Inc()
+	}
+}
+
+// observeBatch records the outcome of handling a batch. When the batch fails its
+// messages are handled one by one and counted there.
+func observeBatch(client, topic string, count int, duration time.Duration, err error) {
+	consumerBatchDuration.WithLabelValues(client, topic).Observe(duration.Seconds())
+	if err == nil {
+		consumerMessagesTotal.WithLabelValues(client, topic).Add(float64(count))
+	}
+}
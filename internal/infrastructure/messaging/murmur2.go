@@ -0,0 +1,83 @@
+package messaging
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+// murmur2 implements hash.Hash32 with the murmur2 variant of the Java Kafka client.
+// Sarama has no such hasher, Franz-Go and librdkafka partition keys with it by default.
+type murmur2 struct {
+	data []byte
+}
+
+// newMurmur2 returns an empty murmur2 hash
+func newMurmur2() hash.Hash32 {
+	return &murmur2{}
+}
+
+// Write buffers the key, murmur2 is computed over the whole input at once
+func (h *murmur2) Write(p []byte) (int, error) {
+	h.data = append(h.data, p...)
+	return len(p), nil
+}
+
+// Sum appends the big-endian hash to b
+func (h *murmur2) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint32(b, h.Sum32())
+}
+
+// Reset clears the buffered input
+func (h *murmur2) Reset() {
+	h.data = h.data[:0]
+}
+
+// Size returns the number of bytes Sum appends
+func (h *murmur2) Size() int {
+	return 4
+}
+
+// BlockSize returns the number of bytes hashed per round
+func (h *murmur2) BlockSize() int {
+	return 4
+}
+
+// Sum32 returns the murmur2 hash of the buffered input with the Java client's seed
+func (h *murmur2) Sum32() uint32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	b := h.data
+	sum := seed ^ uint32(len(b))
+
+	for ; len(b) >= 4; b = b[4:] {
+		k := binary.LittleEndian.Uint32(b)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		sum *= m
+		sum ^= k
+	}
+
+	switch len(b) {
+	case 3:
+		sum ^= uint32(b[2]) << 16
+		fallthrough
+	case 2:
+		sum ^= uint32(b[1]) << 8
+		fallthrough
+	case 1:
+		sum ^= uint32(b[0])
+		sum *= m
+	}
+
+	sum ^= sum >> 13
+	sum *= m
+	sum ^= sum >> 15
+
+	return sum
+}
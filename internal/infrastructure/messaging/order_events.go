@@ -0,0 +1,85 @@
+package messaging
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"goEvents/internal/domain/model"
+	"goEvents/internal/domain/service"
+	"goEvents/internal/infrastructure/messaging/event"
+	"strconv"
+)
+
+// OrderEventHandler applies order events through the order service
+type OrderEventHandler struct {
+	orderService *service.OrderService
+}
+
+// NewOrderEventHandler creates a handler that applies order events through the given service
+func NewOrderEventHandler(orderService *service.OrderService) *OrderEventHandler {
+	return &OrderEventHandler{
+		orderService: orderService,
+	}
+}
+
+// Handle decodes an order event and applies it through the order service
+func (h *OrderEventHandler) Handle(ctx context.Context, msg *Message) error {
+	evt, err := event.Decode(msg.Value)
+	if err != nil {
+		return err
+	}
+
+	switch evt.EventType {
+	case event.TypeOrderPlaced:
+		if _, err := h.orderService.CreateOrder(ctx, evt.Order.Description, evt.Order.Quantity); err != nil {
+			return err
+		}
+	default:
+		logrus.WithFields(logrus.Fields{
+			"event_id":   evt.EventID,
+			"event_type": evt.EventType,
+		}).Debug("Ignoring order event type")
+	}
+
+	return nil
+}
+
+// HandleBatch decodes a batch of order events and creates the placed orders with one batch insert
+func (h *OrderEventHandler) HandleBatch(ctx context.Context, msgs []*Message) error {
+	orders := make([]*model.Order, 0, len(msgs))
+	for _, msg := range msgs {
+		evt, err := event.Decode(msg.Value)
+		if err != nil {
+			return err
+		}
+
+		if evt.EventType != event.TypeOrderPlaced {
+			logrus.WithFields(logrus.Fields{
+				"event_id":   evt.EventID,
+				"event_type": evt.EventType,
+			}).Debug("Ignoring order event type")
+			continue
+		}
+
+		orders = append(orders, &model.Order{
+			Description: evt.Order.Description,
+			Quantity:    evt.Order.Quantity,
+		})
+	}
+
+	if len(orders) == 0 {
+		return nil
+	}
+
+	return h.orderService.CreateOrders(ctx, orders)
+}
+
+// orderEventHeaders returns the record headers describing an encoded order event,
+// followed by the correlation ID and trace context of ctx
+func orderEventHeaders(ctx context.Context, evt *event.OrderEvent) []Header {
+	headers := []Header{
+		{Key: event.HeaderEventType, Value: []byte(evt.EventType)},
+		{Key: event.HeaderSchemaVersion, Value: []byte(strconv.Itoa(evt.SchemaVersion))},
+		{Key: event.HeaderContentType, Value: []byte(event.ContentTypeJSON)},
+	}
+	return append(headers, correlationHeaders(ctx)...)
+}
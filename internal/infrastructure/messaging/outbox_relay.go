@@ -0,0 +1,167 @@
+package messaging
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"goEvents/internal/correlation"
+	"goEvents/internal/domain/repository"
+	"goEvents/internal/infrastructure/messaging/event"
+	"sync"
+	"time"
+)
+
+// OutboxRelayConfig holds configuration for the outbox relay
+type OutboxRelayConfig struct {
+	// PollInterval is how long the relay waits between polls when the outbox is drained
+	PollInterval time.Duration
+	// BatchSize is the maximum number of messages read from the outbox per poll
+	BatchSize int
+}
+
+// DefaultOutboxRelayConfig returns a configuration with reasonable defaults
+func DefaultOutboxRelayConfig() OutboxRelayConfig {
+	return OutboxRelayConfig{
+		PollInterval: time.Second,
+		BatchSize:    100,
+	}
+}
+
+// OutboxRelay polls the transactional outbox and publishes pending events.
+// A message is only marked as sent after the producer accepted it, so events
+// are delivered at least once and may be repeated after a crash; consumers
+// can deduplicate on the event ID.
+type OutboxRelay struct {
+	outbox   repository.OutboxRepository
+	producer MessageProducer
+	config   OutboxRelayConfig
+	wg       sync.WaitGroup
+}
+
+// NewOutboxRelay creates a relay that publishes outbox messages through the given producer
+func NewOutboxRelay(outbox repository.OutboxRepository, producer MessageProducer, config OutboxRelayConfig) *OutboxRelay {
+	defaults := DefaultOutboxRelayConfig()
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaults.PollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+
+	return &OutboxRelay{
+		outbox:   outbox,
+		producer: producer,
+		config:   config,
+	}
+}
+
+// Start begins relaying outbox messages in a goroutine
+func (r *OutboxRelay) Start(ctx context.Context) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(ctx)
+	}()
+}
+
+// Wait waits for the relay goroutine to finish
+func (r *OutboxRelay) Wait() {
+	r.wg.Wait()
+}
+
+// run polls the outbox until the context is canceled
+func (r *OutboxRelay) run(ctx context.Context) {
+	logrus.WithFields(logrus.Fields{
+		"poll_interval": r.config.PollInterval.String(),
+		"batch_size":    r.config.BatchSize,
+	}).Info("Outbox relay started")
+
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep draining while full batches come back, then wait for the next tick
+		for {
+			relayed, err := r.relayBatch(ctx)
+			if err != nil {
+				logrus.WithError(err).Error("Outbox relay batch failed")
+				break
+			}
+			if relayed < r.config.BatchSize || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logrus.Info("Context canceled, stopping outbox relay")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayBatch publishes one batch of pending messages and returns how many were sent
+func (r *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	messages, err := r.outbox.FetchPendingOutbox(ctx, r.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := make([]uint64, 0, len(messages))
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Publish on behalf of the request that wrote the message, in a span of its trace
+		msgCtx := correlation.WithRemoteParent(ctx, message.TraceParent)
+		msgCtx = correlation.Continue(msgCtx, message.CorrelationID, message.TraceParent)
+
+		evt, err := event.Decode(message.Payload)
+		if err != nil {
+			// A malformed payload can never be published, take it out of the polled rows and move on
+			correlation.Logger(msgCtx).WithError(err).WithField("outbox_id", message.ID).Error("Invalid outbox payload, marking it dead")
+			r.recordDead(ctx, message.ID, err)
+			continue
+		}
+
+		if err := r.producer.PublishOrder(msgCtx, evt); err != nil {
+			// Stop at the first failure so later events for the same order are not published out of order
+			correlation.Logger(msgCtx).WithError(err).WithFields(logrus.Fields{
+				"outbox_id": message.ID,
+				"event_id":  message.EventID,
+			}).Warn("Failed to publish outbox message, will retry")
+			r.recordFailure(ctx, message.ID, err)
+			break
+		}
+
+		sent = append(sent, message.ID)
+	}
+
+	// Published messages are marked even during shutdown, so they are not sent twice
+	if err := r.outbox.MarkOutboxSent(context.WithoutCancel(ctx), sent); err != nil {
+		// The messages will be published again on the next poll
+		return 0, err
+	}
+
+	if len(sent) > 0 {
+		logrus.WithField("count", len(sent)).Debug("Outbox messages relayed")
+	}
+
+	return len(sent), nil
+}
+
+// recordDead marks a message that can never be published, logging if that fails.
+// Until the mark succeeds the message is fetched and rejected again on every poll.
+func (r *OutboxRelay) recordDead(ctx context.Context, id uint64, cause error) {
+	if err := r.outbox.MarkOutboxDead(context.WithoutCancel(ctx), id, cause.Error()); err != nil {
+		logrus.WithError(err).WithField("outbox_id", id).Error("Failed to mark outbox message as dead")
+	}
+}
+
+// recordFailure stores a failed publish attempt, logging if that fails too
+func (r *OutboxRelay) recordFailure(ctx context.Context, id uint64, cause error) {
+	if err := r.outbox.MarkOutboxFailed(context.WithoutCancel(ctx), id, cause.Error()); err != nil {
+		logrus.WithError(err).WithField("outbox_id", id).Error("Failed to record outbox failure")
+	}
+}
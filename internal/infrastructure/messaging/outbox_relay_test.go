@@ -0,0 +1,135 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"goEvents/internal/domain/model"
+	"goEvents/internal/infrastructure/messaging/event"
+	"sync"
+	"testing"
+)
+
+// memoryOutbox is an outbox repository keeping its messages in memory
+type memoryOutbox struct {
+	mu       sync.Mutex
+	messages []*model.OutboxMessage
+	sent     map[uint64]bool
+	dead     map[uint64]string
+	failed   map[uint64]int
+}
+
+func newMemoryOutbox(messages ...*model.OutboxMessage) *memoryOutbox {
+	return &memoryOutbox{
+		messages: messages,
+		sent:     make(map[uint64]bool),
+		dead:     make(map[uint64]string),
+		failed:   make(map[uint64]int),
+	}
+}
+
+func (o *memoryOutbox) FetchPendingOutbox(_ context.Context, limit int) ([]*model.OutboxMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var pending []*model.OutboxMessage
+	for _, message := range o.messages {
+		_, dead := o.dead[message.ID]
+		if !o.sent[message.ID] && !dead && len(pending) < limit {
+			pending = append(pending, message)
+		}
+	}
+	return pending, nil
+}
+
+func (o *memoryOutbox) MarkOutboxSent(_ context.Context, ids []uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, id := range ids {
+		o.sent[id] = true
+	}
+	return nil
+}
+
+func (o *memoryOutbox) MarkOutboxFailed(_ context.Context, id uint64, _ string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.failed[id]++
+	return nil
+}
+
+func (o *memoryOutbox) MarkOutboxDead(_ context.Context, id uint64, reason string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.dead[id] = reason
+	return nil
+}
+
+// recordingProducer records the IDs of the published events and fails while down is set
+type recordingProducer struct {
+	down      bool
+	published []string
+}
+
+func (p *recordingProducer) Initialize() error          { return nil }
+func (p *recordingProducer) Ping(context.Context) error { return nil }
+func (p *recordingProducer) Shutdown(context.Context)   {}
+
+func (p *recordingProducer) PublishOrder(_ context.Context, evt *event.OrderEvent) error {
+	if p.down {
+		return errors.New("brokers down")
+	}
+	p.published = append(p.published, evt.EventID)
+	return nil
+}
+
+// outboxTestMessage encodes an order event as an outbox message
+func outboxTestMessage(t *testing.T, id uint64) *model.OutboxMessage {
+	evt := event.NewOrderEvent(event.TypeOrderCreated, &model.Order{ID: uint(id), Description: "relayed", Quantity: 1})
+	payload, err := event.Encode(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &model.OutboxMessage{ID: id, EventID: evt.EventID, EventType: evt.EventType, Payload: payload}
+}
+
+// TestOutboxRelayMarksUndecodablePayloadsDead polls an outbox whose first row cannot be
+// decoded and checks that it is marked dead once instead of being fetched on every poll,
+// while a failed publish is kept for the next poll
+func TestOutboxRelayMarksUndecodablePayloadsDead(t *testing.T) {
+	outbox := newMemoryOutbox(
+		&model.OutboxMessage{ID: 1, Payload: []byte("not json")},
+		outboxTestMessage(t, 2),
+		outboxTestMessage(t, 3),
+	)
+	producer := &recordingProducer{down: true}
+	relay := NewOutboxRelay(outbox, producer, OutboxRelayConfig{BatchSize: 1})
+
+	// The bad row takes the whole first batch, the next poll stops at the failed publish
+	for poll := 0; poll < 2; poll++ {
+		if _, err := relay.relayBatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := outbox.dead[1]; !ok {
+		t.Fatal("undecodable message was not marked dead")
+	}
+	if outbox.failed[2] != 1 || outbox.sent[2] {
+		t.Fatalf("message 2 failed %d times and sent %v, want one failure, not sent", outbox.failed[2], outbox.sent[2])
+	}
+
+	producer.down = false
+	for poll := 0; poll < 3; poll++ {
+		if _, err := relay.relayBatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{outbox.messages[1].EventID, outbox.messages[2].EventID}
+	if len(producer.published) != len(want) || producer.published[0] != want[0] || producer.published[1] != want[1] {
+		t.Errorf("published %v, want %v", producer.published, want)
+	}
+	if outbox.failed[1] != 0 || outbox.sent[1] {
+		t.Error("dead message was fetched again")
+	}
+}
@@ -0,0 +1,134 @@
+package messaging
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"goEvents/internal/correlation"
+	"goEvents/internal/infrastructure/messaging/event"
+	"time"
+)
+
+// messageProcessor passes consumed messages to the handler and routes the ones that fail.
+// It is shared by all client implementations so they behave the same way.
+type messageProcessor struct {
+	client       string
+	handler      MessageHandler
+	batchHandler BatchHandler
+	publisher    recordPublisher
+	retry        *retryQueue
+	deadLetter   *deadLetterQueue
+	commitMode   CommitMode
+}
+
+// newMessageProcessor creates a processor for the named client, enabling retry tiers
+// and the dead-letter topic when they are configured and a publisher is given
+func newMessageProcessor(handler MessageHandler, config *ConsumerConfig, client string, publisher recordPublisher) *messageProcessor {
+	p := &messageProcessor{
+		client:     client,
+		handler:    handler,
+		publisher:  publisher,
+		commitMode: config.commitMode(),
+	}
+
+	// Batches are only gathered for handlers able to process them
+	if batchHandler, ok := handler.(BatchHandler); ok && config.BatchSize > 1 {
+		p.batchHandler = batchHandler
+	}
+
+	if publisher == nil {
+		return p
+	}
+
+	if len(config.RetryTiers) > 0 {
+		p.retry = &retryQueue{
+			tiers:     config.RetryTiers,
+			publisher: publisher,
+		}
+	}
+
+	if config.DeadLetterTopic != "" {
+		p.deadLetter = &deadLetterQueue{
+			topic:     config.DeadLetterTopic,
+			publisher: publisher,
+		}
+	}
+
+	return p
+}
+
+// process handles a single message. It returns an error when the message failed
+// and could not be forwarded to a retry or dead-letter topic (or none is configured
+// outside auto commit mode), or when the context was canceled while waiting for a
+// retry to become due.
+func (p *messageProcessor) process(ctx context.Context, msg *Message) error {
+	// Records from retry topics are held until their due time
+	if err := waitUntilDue(ctx, msg); err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+
+	fields := correlation.Fields(ctx)
+	fields["topic"] = msg.Topic
+	fields["partition"] = msg.Partition
+	fields["offset"] = msg.Offset
+
+	if eventType, ok := msg.Header(event.HeaderEventType); ok {
+		fields["event_type"] = eventType
+	}
+
+	err := p.handler.Handle(ctx, msg)
+	duration := time.Since(startTime)
+	observeHandled(p.client, msg.Topic, duration, err)
+
+	if err != nil {
+		// The span only fails if the message cannot be retried or dead-lettered either
+		trace.SpanFromContext(ctx).RecordError(err)
+		logrus.WithError(err).WithFields(fields).Error("Error handling message")
+		return p.handleFailure(ctx, msg, err)
+	}
+
+	// Calculate processing time in milliseconds
+	fields["processing_time_ms"] = duration.Milliseconds()
+
+	logrus.WithFields(fields).Info("Message processed")
+
+	return nil
+}
+
+// handleFailure forwards a failed message to the next retry tier, or to the
+// dead-letter topic once the retry tiers are exhausted
+func (p *messageProcessor) handleFailure(ctx context.Context, msg *Message, cause error) error {
+	if p.retry != nil {
+		if tier, attempt, ok := p.retry.next(msg.Topic); ok {
+			if err := p.retry.send(ctx, msg, tier, attempt, cause); err != nil {
+				logrus.WithError(err).Error("Message could not be scheduled for retry")
+				return err
+			}
+			return nil
+		}
+	}
+
+	if p.deadLetter == nil {
+		// Without a destination the record is skipped in auto mode and kept otherwise
+		if p.commitMode == CommitModeAuto {
+			return nil
+		}
+		return cause
+	}
+
+	if err := p.deadLetter.send(ctx, msg, cause); err != nil {
+		logrus.WithError(err).Error("Message could not be dead-lettered")
+		return err
+	}
+
+	return nil
+}
+
+// close releases the resources used to route failed messages
+func (p *messageProcessor) close() {
+	if p.publisher != nil {
+		p.publisher.close()
+	}
+}
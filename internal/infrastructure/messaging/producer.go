@@ -2,6 +2,15 @@ package messaging
 
 import (
 	"context"
+	"errors"
+	"goEvents/internal/infrastructure/messaging/event"
+)
+
+var (
+	// ErrProducerNotInitialized is returned when the producer is pinged before Initialize or after Shutdown
+	ErrProducerNotInitialized = errors.New("producer not initialized")
+	// errNoBrokers is returned when a metadata response lists no brokers
+	errNoBrokers = errors.New("metadata lists no brokers")
 )
 
 // MessageProducer defines the interface for message producing systems
@@ -9,8 +18,12 @@ type MessageProducer interface {
 	// Initialize sets up the producer
 	Initialize() error
 
-	// PublishOrder publishes an order message to the configured topic
-	PublishOrder(orderID string) error
+	// PublishOrder encodes an order event and publishes it to the configured topic
+	PublishOrder(ctx context.Context, evt *event.OrderEvent) error
+
+	// Ping fetches the metadata of the configured topic from the brokers, failing when
+	// no broker answers or the topic cannot be produced to
+	Ping(ctx context.Context) error
 
 	// Shutdown gracefully shuts down the producer
 	Shutdown(ctx context.Context)
@@ -0,0 +1,28 @@
+package messaging
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Assignor selects how a consumer group spreads partitions over its members
+type Assignor string
+
+const (
+	// AssignorRange assigns contiguous partition ranges of each topic
+	AssignorRange Assignor = "range"
+	// AssignorRoundRobin assigns partitions of all topics one by one
+	AssignorRoundRobin Assignor = "roundrobin"
+	// AssignorCooperativeSticky keeps partitions where they are and only moves the ones
+	// that must change owner, without stopping the whole group during a rebalance.
+	// Sarama has no cooperative protocol, so CheckConsumer and NewConsumer reject it.
+	AssignorCooperativeSticky Assignor = "cooperative-sticky"
+)
+
+// logPartitions logs a change of the partitions assigned to a consumer
+func logPartitions(client, groupID, change string, partitions map[string][]int32) {
+	logrus.WithFields(logrus.Fields{
+		"client":     client,
+		"group_id":   groupID,
+		"partitions": partitions,
+	}).Info("Partitions " + change)
+}
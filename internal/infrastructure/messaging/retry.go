@@ -0,0 +1,130 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+// Header names added to records forwarded to a retry topic
+const (
+	HeaderRetryDueAt   = "x-retry-due-at"
+	HeaderRetryAttempt = "x-retry-attempt"
+)
+
+// RetryTier is a retry topic and how long its records wait before being reprocessed
+type RetryTier struct {
+	// Topic receives records that failed in the previous tier (or the main topic)
+	Topic string
+	// Delay is the minimum time between the failure and the next attempt
+	Delay time.Duration
+}
+
+// DefaultRetryTiers returns the standard 5s, 1m and 10m retry tiers for a topic,
+// e.g. orders.retry.5s, orders.retry.1m and orders.retry.10m
+func DefaultRetryTiers(topic string) []RetryTier {
+	return []RetryTier{
+		{Topic: topic + ".retry.5s", Delay: 5 * time.Second},
+		{Topic: topic + ".retry.1m", Delay: time.Minute},
+		{Topic: topic + ".retry.10m", Delay: 10 * time.Minute},
+	}
+}
+
+// retryQueue forwards failed records to the next retry tier
+type retryQueue struct {
+	tiers     []RetryTier
+	publisher recordPublisher
+}
+
+// next returns the tier a record consumed from topic should be retried in.
+// Records from the last tier have no next tier and go to the dead-letter topic.
+func (q *retryQueue) next(topic string) (RetryTier, int, bool) {
+	index := 0
+	for i, tier := range q.tiers {
+		if tier.Topic == topic {
+			index = i + 1
+			break
+		}
+	}
+
+	if index >= len(q.tiers) {
+		return RetryTier{}, 0, false
+	}
+	return q.tiers[index], index + 1, true
+}
+
+// send republishes the message to the retry tier with its due time
+func (q *retryQueue) send(ctx context.Context, msg *Message, tier RetryTier, attempt int, cause error) error {
+	now := time.Now()
+	dueAt := now.Add(tier.Delay)
+
+	headers := failureHeaders(msg, cause, now)
+	headers = withoutHeaders(headers, HeaderRetryDueAt, HeaderRetryAttempt)
+	headers = append(headers,
+		Header{Key: HeaderRetryDueAt, Value: []byte(strconv.FormatInt(dueAt.UnixMilli(), 10))},
+		Header{Key: HeaderRetryAttempt, Value: []byte(strconv.Itoa(attempt))},
+	)
+
+	if err := q.publisher.publish(ctx, tier.Topic, msg.Key, msg.Value, headers); err != nil {
+		return fmt.Errorf("failed to publish to retry topic %s: %w", tier.Topic, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"retry_topic":   tier.Topic,
+		"retry_attempt": attempt,
+		"due_at":        dueAt.UTC().Format(time.RFC3339),
+		"topic":         msg.Topic,
+		"partition":     msg.Partition,
+		"offset":        msg.Offset,
+	}).Warn("Message scheduled for retry")
+
+	return nil
+}
+
+// waitUntilDue blocks until the record's retry due time has passed or the context is canceled
+func waitUntilDue(ctx context.Context, msg *Message) error {
+	value, ok := msg.Header(HeaderRetryDueAt)
+	if !ok {
+		return nil
+	}
+
+	dueAtMs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	wait := time.Until(time.UnixMilli(dueAtMs))
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withoutHeaders returns the headers minus the ones with the given keys
+func withoutHeaders(headers []Header, keys ...string) []Header {
+	result := headers[:0:0]
+	for _, h := range headers {
+		drop := false
+		for _, key := range keys {
+			if h.Key == key {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			result = append(result, h)
+		}
+	}
+	return result
+}
@@ -0,0 +1,173 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goEvents/internal/infrastructure/messaging/event"
+)
+
+// ErrNoRoute is returned when no handler matches a message
+var ErrNoRoute = errors.New("no handler registered for message")
+
+// routeKey identifies a route, an empty field matches any value
+type routeKey struct {
+	topic     string
+	eventType string
+}
+
+// Router is a MessageHandler that dispatches messages to other handlers by topic and
+// event type. The most specific route wins: topic and event type, then topic only,
+// then event type only, then the fallback handler.
+//
+// Records consumed from retry topics are routed by their x-original-topic header, so
+// a handler registered for "orders" also receives the records of its retry tiers.
+type Router struct {
+	routes   map[routeKey]MessageHandler
+	fallback MessageHandler
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{
+		routes: make(map[routeKey]MessageHandler),
+	}
+}
+
+// Route registers a handler for a topic and event type, either may be empty to match any
+func (r *Router) Route(topic, eventType string, handler MessageHandler) *Router {
+	r.routes[routeKey{topic: topic, eventType: eventType}] = handler
+	return r
+}
+
+// HandleTopic registers a handler for every message of a topic
+func (r *Router) HandleTopic(topic string, handler MessageHandler) *Router {
+	return r.Route(topic, "", handler)
+}
+
+// HandleEventType registers a handler for every message of an event type, on any topic
+func (r *Router) HandleEventType(eventType string, handler MessageHandler) *Router {
+	return r.Route("", eventType, handler)
+}
+
+// Fallback registers the handler used when no route matches
+func (r *Router) Fallback(handler MessageHandler) *Router {
+	r.fallback = handler
+	return r
+}
+
+// Handle dispatches the message to the most specific matching handler
+func (r *Router) Handle(ctx context.Context, msg *Message) error {
+	_, handler, err := r.resolve(msg)
+	if err != nil {
+		return err
+	}
+	return handler.Handle(ctx, msg)
+}
+
+// HandleBatch passes each run of consecutive messages with the same route to its handler,
+// in one call for batch handlers and message by message for the others. Runs keep the
+// order of the batch, so the messages before a failed run were applied; the returned
+// *PartialBatchError tells the consumer to handle the rest one by one from there.
+func (r *Router) HandleBatch(ctx context.Context, msgs []*Message) error {
+	// Every message is resolved first, so a message without a route fails the batch untouched
+	keys := make([]routeKey, len(msgs))
+	handlers := make([]MessageHandler, len(msgs))
+	for i, msg := range msgs {
+		key, handler, err := r.resolve(msg)
+		if err != nil {
+			return err
+		}
+		keys[i], handlers[i] = key, handler
+	}
+
+	for start := 0; start < len(msgs); {
+		end := start + 1
+		for end < len(msgs) && keys[end] == keys[start] {
+			end++
+		}
+
+		if applied, err := handleRun(ctx, handlers[start], msgs[start:end]); err != nil {
+			if start+applied == 0 {
+				return err
+			}
+			return &PartialBatchError{Handled: start + applied, Err: err}
+		}
+		start = end
+	}
+
+	return nil
+}
+
+// handleRun passes messages of one route to its handler. When it fails it returns how
+// many leading messages were applied, with the error that stopped it.
+func handleRun(ctx context.Context, handler MessageHandler, msgs []*Message) (int, error) {
+	if batchHandler, ok := handler.(BatchHandler); ok {
+		err := batchHandler.HandleBatch(ctx, msgs)
+		var partial *PartialBatchError
+		if errors.As(err, &partial) {
+			return partial.Handled, partial.Err
+		}
+		return 0, err
+	}
+
+	for i, msg := range msgs {
+		if err := handler.Handle(ctx, msg); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// resolve finds the most specific route for a message. The fallback handler is
+// returned with the zero route key, which is never registered as a route.
+func (r *Router) resolve(msg *Message) (routeKey, MessageHandler, error) {
+	topic := routedTopic(msg)
+	eventType := messageEventType(msg)
+
+	candidates := []routeKey{
+		{topic: topic, eventType: eventType},
+		{topic: topic},
+		{eventType: eventType},
+	}
+
+	for _, key := range candidates {
+		if key.eventType == "" && key.topic == "" {
+			continue
+		}
+		if handler, ok := r.routes[key]; ok {
+			return key, handler, nil
+		}
+	}
+
+	if r.fallback != nil {
+		return routeKey{}, r.fallback, nil
+	}
+
+	return routeKey{}, nil, fmt.Errorf("%w: topic %q, event type %q", ErrNoRoute, topic, eventType)
+}
+
+// routedTopic returns the topic a message was originally published to
+func routedTopic(msg *Message) string {
+	if topic, ok := msg.Header(HeaderOriginalTopic); ok && topic != "" {
+		return topic
+	}
+	return msg.Topic
+}
+
+// messageEventType returns the event type from the record header, falling back to
+// the event_type field of a JSON payload for records published without headers
+func messageEventType(msg *Message) string {
+	if eventType, ok := msg.Header(event.HeaderEventType); ok {
+		return eventType
+	}
+
+	var envelope struct {
+		EventType string `json:"event_type"`
+	}
+	if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+		return ""
+	}
+	return envelope.EventType
+}
@@ -0,0 +1,67 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// countingBatchHandler counts how often each offset was applied, in a batch or on its own
+type countingBatchHandler struct {
+	mu      sync.Mutex
+	applied map[int64]int
+}
+
+func (h *countingBatchHandler) Handle(_ context.Context, msg *Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.applied[msg.Offset]++
+	return nil
+}
+
+func (h *countingBatchHandler) HandleBatch(_ context.Context, msgs []*Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, msg := range msgs {
+		h.applied[msg.Offset]++
+	}
+	return nil
+}
+
+// TestRouterBatchFailureKeepsAppliedRoutes fails the second route of a batch and checks
+// that the router reports the applied leading messages and the consumer's fallback
+// handles none of them a second time
+func TestRouterBatchFailureKeepsAppliedRoutes(t *testing.T) {
+	orders := &countingBatchHandler{applied: make(map[int64]int)}
+	errPayments := errors.New("payments down")
+	router := NewRouter().
+		HandleTopic("orders", orders).
+		HandleTopic("payments", MessageHandlerFunc(func(context.Context, *Message) error {
+			return errPayments
+		}))
+
+	var msgs []*Message
+	for offset, topic := range []string{"orders", "orders", "payments", "orders"} {
+		msgs = append(msgs, &Message{Topic: topic, Offset: int64(offset)})
+	}
+
+	err := router.HandleBatch(context.Background(), msgs)
+	var partial *PartialBatchError
+	if !errors.As(err, &partial) || partial.Handled != 2 || !errors.Is(err, errPayments) {
+		t.Fatalf("HandleBatch() = %v, want a partial batch error after 2 messages", err)
+	}
+
+	// The consumer falls back to the remaining messages, auto mode moves past the failure
+	orders.applied = make(map[int64]int)
+	processor := newMessageProcessor(router, &ConsumerConfig{BatchSize: len(msgs), CommitMode: CommitModeAuto}, "test", nil)
+	handled, err := processor.handleBatch(context.Background(), msgs)
+	if err != nil || handled != len(msgs) {
+		t.Fatalf("handleBatch() = %d, %v, want %d, nil", handled, err, len(msgs))
+	}
+	for _, offset := range []int64{0, 1, 3} {
+		if got := orders.applied[offset]; got != 1 {
+			t.Errorf("orders offset %d applied %d times, want once", offset, got)
+		}
+	}
+}
@@ -2,47 +2,49 @@ package messaging
 
 import (
 	"context"
+	"fmt"
 	"github.com/IBM/sarama"
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"goEvents/internal/domain/service"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // SaramaKafkaConsumer implements the MessageConsumer interface using Sarama Kafka client
 type SaramaKafkaConsumer struct {
-	orderService   *service.OrderService
-	config         *ConsumerConfig
-	client         sarama.ConsumerGroup
-	wg             sync.WaitGroup
-	consumerClosed chan struct{}
+	handler MessageHandler
+	config  *ConsumerConfig
+	wg      sync.WaitGroup
+	lag     lagRegistry
+	groups  groupMembership
 }
 
-// NewSaramaKafkaConsumer creates a new Kafka consumer with the given order service
-func NewSaramaKafkaConsumer(orderService *service.OrderService, config *ConsumerConfig) *SaramaKafkaConsumer {
+// NewSaramaKafkaConsumer creates a new Kafka consumer passing messages to the given handler
+func NewSaramaKafkaConsumer(handler MessageHandler, config *ConsumerConfig) *SaramaKafkaConsumer {
 	if config == nil {
 		logrus.Fatal("Kafka configuration must be provided")
 	}
 
 	return &SaramaKafkaConsumer{
-		orderService:   orderService,
-		config:         config,
-		consumerClosed: make(chan struct{}),
+		handler: handler,
+		config:  config,
 	}
 }
 
-// Start begins consuming messages in a goroutine
+// Start begins consuming messages in a goroutine, plus one per retry tier
 func (c *SaramaKafkaConsumer) Start(ctx context.Context) {
-	c.wg.Add(1)
-	go func() {
-		defer c.wg.Done()
-		c.consume(ctx)
-	}()
+	for _, sub := range c.config.subscriptions() {
+		c.groups.expect(sub.GroupID)
+		c.wg.Add(1)
+		go func(sub subscription) {
+			defer c.wg.Done()
+			c.consume(ctx, sub)
+		}(sub)
+	}
 }
 
 // consume handles the actual message consumption
-func (c *SaramaKafkaConsumer) consume(ctx context.Context) {
+func (c *SaramaKafkaConsumer) consume(ctx context.Context, sub subscription) {
 	// Initialize Sarama configuration
 	config := sarama.NewConfig()
 
@@ -57,15 +59,42 @@ func (c *SaramaKafkaConsumer) consume(ctx context.Context) {
 		config.Consumer.Offsets.Initial = sarama.OffsetNewest
 	}
 
+	// Offsets are committed by the handler itself unless auto commit is requested
+	config.Consumer.Offsets.AutoCommit.Enable = c.config.commitMode() == CommitModeAuto
+
+	if strategy, ok := saramaBalanceStrategy(c.config.Assignor); ok {
+		config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{strategy}
+	}
+
+	// Records held for a retry tier must not exceed the rebalance timeout
+	if timeout := sub.MaxDelay + time.Minute; sub.MaxDelay > 0 && timeout > config.Consumer.Group.Rebalance.Timeout {
+		config.Consumer.Group.Rebalance.Timeout = timeout
+	}
+
+	if err := applySaramaSecurity(config, c.config.Security); err != nil {
+		logrus.WithError(err).Fatal("Invalid Sarama security configuration")
+		return
+	}
+
+	// The group is created from a client so the lag can be queried with the same connections
+	saramaClient, err := sarama.NewClient(c.config.brokers(), config)
+	if err != nil {
+		logrus.WithError(err).Fatal("Error creating Sarama client")
+		return
+	}
+	defer func() {
+		if err := saramaClient.Close(); err != nil {
+			logrus.WithError(err).Error("Error closing Sarama client")
+		}
+	}()
+
 	// Create consumer group
-	client, err := sarama.NewConsumerGroup([]string{c.config.BootstrapServers}, c.config.GroupID, config)
+	client, err := sarama.NewConsumerGroupFromClient(sub.GroupID, saramaClient)
 	if err != nil {
 		logrus.WithError(err).Fatal("Error creating Sarama consumer group")
 		return
 	}
 
-	c.client = client
-
 	// Track errors from the consumer group
 	go func() {
 		for err := range client.Errors() {
@@ -73,24 +102,51 @@ func (c *SaramaKafkaConsumer) consume(ctx context.Context) {
 		}
 	}()
 
+	// Records that failed processing are published with a dedicated producer
+	var publisher recordPublisher
+	if c.config.republishes() {
+		saramaPublisher, err := newSaramaRecordPublisher(c.config.brokers(), c.config.Security)
+		if err != nil {
+			logrus.WithError(err).Fatal("Error creating Sarama dead-letter producer")
+			return
+		}
+		publisher = saramaPublisher
+	}
+
+	processor := newMessageProcessor(c.handler, c.config, "sarama", publisher)
+	defer processor.close()
+
+	flow := newFlowController(c.config, "sarama", sub.GroupID, client.PauseAll, client.ResumeAll)
+
 	// Create a handler for the consumer group
 	handler := &saramaConsumerGroupHandler{
-		orderService: c.orderService,
+		ctx:        ctx,
+		processor:  processor,
+		config:     c.config,
+		groupID:    sub.GroupID,
+		flow:       flow,
+		assignment: newAssignedPartitions(),
 	}
 
+	c.lag.register(sub.GroupID, func(ctx context.Context) ([]PartitionLag, error) {
+		return saramaLag(ctx, saramaClient, sub.GroupID, handler.assignment.snapshot())
+	})
+	c.groups.track(sub.GroupID, handler.joined.Load)
+
 	logrus.WithFields(logrus.Fields{
 		"bootstrap_servers": c.config.BootstrapServers,
-		"group_id":          c.config.GroupID,
-		"topics":            c.config.Topics,
+		"group_id":          sub.GroupID,
+		"topics":            sub.Topics,
 	}).Info("Sarama Kafka consumer started and waiting for messages")
 
 	// Consume in a loop until context is canceled
+	consumerClosed := make(chan struct{})
 	go func() {
-		defer close(c.consumerClosed)
+		defer close(consumerClosed)
 		for {
 			// Consume should be called inside an infinite loop, as each call only consumes a single batch of messages
 			// The context passed to Consume controls the lifetime of the consumer session
-			if err := client.Consume(ctx, c.config.Topics, handler); err != nil {
+			if err := client.Consume(ctx, sub.Topics, handler); err != nil {
 				logrus.WithError(err).Error("Error from consumer")
 			}
 
@@ -103,10 +159,13 @@ func (c *SaramaKafkaConsumer) consume(ctx context.Context) {
 	}()
 
 	// Wait for consumer to be closed
-	<-c.consumerClosed
+	<-consumerClosed
 	logrus.Info("Sarama Kafka consumer closed")
 
-	// Close the client
+	// Close the client once no probe can resume it and no lag query uses it anymore
+	flow.close()
+	c.lag.unregister(sub.GroupID)
+	c.groups.track(sub.GroupID, nil)
 	if err := client.Close(); err != nil {
 		logrus.WithError(err).Error("Error closing Sarama consumer group")
 	}
@@ -117,48 +176,384 @@ func (c *SaramaKafkaConsumer) Wait() {
 	c.wg.Wait()
 }
 
+// Lag returns the lag of the partitions assigned to the consumer's clients
+func (c *SaramaKafkaConsumer) Lag(ctx context.Context) ([]PartitionLag, error) {
+	return c.lag.lag(ctx)
+}
+
+// Groups reports whether the consumer's clients are members of their groups
+func (c *SaramaKafkaConsumer) Groups() map[string]bool {
+	return c.groups.groups()
+}
+
+// saramaBalanceStrategy returns the balance strategy for the assignor, or false for the client default
+func saramaBalanceStrategy(assignor Assignor) (sarama.BalanceStrategy, bool) {
+	switch assignor {
+	case AssignorRange:
+		return sarama.NewBalanceStrategyRange(), true
+	case AssignorRoundRobin:
+		return sarama.NewBalanceStrategyRoundRobin(), true
+	case "":
+		return nil, false
+	default:
+		logrus.WithField("assignor", assignor).Warn("Unknown assignor, using the client default")
+		return nil, false
+	}
+}
+
+// saramaLag compares the offsets committed by the group with the oldest and newest offsets
+// of the assigned partitions. Sarama's calls take no context, so they run in a goroutine
+// the query stops waiting for once ctx is done. An abandoned query stops at the next
+// partition; Sarama fails the calls made after the client was closed.
+func saramaLag(ctx context.Context, client sarama.Client, groupID string, assigned map[string][]int32) ([]PartitionLag, error) {
+	if len(assigned) == 0 {
+		return nil, nil
+	}
+
+	type result struct {
+		lags []PartitionLag
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		lags, err := querySaramaLag(ctx, client, groupID, assigned)
+		done <- result{lags: lags, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.lags, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to get lag of group %s: %w", groupID, ctx.Err())
+	}
+}
+
+// querySaramaLag runs the blocking queries of saramaLag
+func querySaramaLag(ctx context.Context, client sarama.Client, groupID string, assigned map[string][]int32) ([]PartitionLag, error) {
+	coordinator, err := client.Coordinator(groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find coordinator of group %s: %w", groupID, err)
+	}
+
+	committed, err := coordinator.FetchOffset(sarama.NewOffsetFetchRequest(client.Config().Version, groupID, assigned))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch offsets of group %s: %w", groupID, err)
+	}
+
+	var lags []PartitionLag
+	for topic, partitions := range assigned {
+		for _, partition := range partitions {
+			committedOffset := int64(-1)
+			if block := committed.GetBlock(topic, partition); block != nil {
+				if block.Err != sarama.ErrNoError {
+					return nil, fmt.Errorf("failed to fetch offset of %s/%d: %w", topic, partition, block.Err)
+				}
+				committedOffset = block.Offset
+			}
+
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			logStart, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get oldest offset of %s/%d: %w", topic, partition, err)
+			}
+			highWatermark, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get newest offset of %s/%d: %w", topic, partition, err)
+			}
+
+			lags = append(lags, newPartitionLag(groupID, topic, partition, committedOffset, logStart, highWatermark))
+		}
+	}
+
+	return lags, nil
+}
+
 // saramaConsumerGroupHandler implements the sarama.ConsumerGroupHandler interface
 type saramaConsumerGroupHandler struct {
-	orderService *service.OrderService
+	// ctx is the consumer's context. Records are handled with it rather than with the
+	// session's, which a rebalance cancels before Cleanup, so queued records are still
+	// handled and committed on revoke and only dropped on shutdown.
+	ctx        context.Context
+	processor  *messageProcessor
+	config     *ConsumerConfig
+	groupID    string
+	flow       *flowController
+	assignment *assignedPartitions
+	dispatcher *dispatcher
+	committer  *saramaCommitter
+	// joined is set while a session runs, Sarama leaves it between the sessions of a rebalance
+	joined atomic.Bool
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (h *saramaConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+func (h *saramaConsumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	logPartitions("sarama", h.groupID, "assigned", session.Claims())
+	h.assignment.add(session.Claims())
+
+	// Workers and commits are scoped to the session so nothing outlives the assignment
+	h.dispatcher = newDispatcher(h.processor, h.config, h.flow)
+	h.committer = newSaramaCommitter(session, h.config)
+
+	// Partitions of a new session start unpaused
+	h.flow.reapply()
+	h.joined.Store(true)
 	return nil
 }
 
-// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (h *saramaConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited.
+// Sarama rebalances eagerly, so every partition of the session is revoked here.
+func (h *saramaConsumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	logPartitions("sarama", h.groupID, "revoked", session.Claims())
+	h.assignment.remove(session.Claims())
+	h.joined.Store(false)
+
+	// Handle the queued records and stop the workers, then commit whatever was marked
+	// before the partitions move on
+	h.dispatcher.drain()
+	h.dispatcher.close()
+	h.committer.flush()
 	return nil
 }
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
 func (h *saramaConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	// Only manual batch mode commits on a timer
+	var tick <-chan time.Time
+	if h.committer.mode == CommitModeManualBatch {
+		ticker := time.NewTicker(h.committer.batch.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	if h.dispatcher.batching() {
+		return h.consumeBatches(session, claim, tick)
+	}
+
 	// Loop over messages in the claim
-	for message := range claim.Messages() {
-		startTime := time.Now()
-		messageID := uuid.New().String()
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
 
-		// Process the message using the domain service
-		description := "Note-" + messageID
-		_, err := h.orderService.CreateOrder(description, 1)
-		if err != nil {
-			logrus.WithError(err).Error("Error creating order")
+			err := h.dispatcher.dispatch(h.ctx, messageFromSarama(message), func() {
+				h.committer.handled(message)
+			})
+			if err != nil && h.ctx.Err() != nil {
+				// The consumer is shutting down, leave the message for the next owner
+				return nil
+			}
+
+		case <-tick:
+			h.committer.tick()
+
+		case <-session.Context().Done():
+			return nil
 		}
+	}
+}
+
+// consumeBatches gathers up to BatchSize messages of the claim, waiting at most
+// BatchTimeout for a batch to fill once its first message arrived
+func (h *saramaConsumerGroupHandler) consumeBatches(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim, tick <-chan time.Time) error {
+	size := h.config.BatchSize
+	timeout := h.config.batchTimeout()
+
+	batch := make([]*sarama.ConsumerMessage, 0, size)
+	timer := time.NewTimer(timeout)
+	timer.Stop()
+	defer timer.Stop()
+
+	// The timeout only runs while a batch is open
+	var expired <-chan time.Time
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				h.handleBatch(batch)
+				return nil
+			}
+
+			batch = append(batch, message)
+			if len(batch) == 1 {
+				timer.Reset(timeout)
+				expired = timer.C
+			}
+			if len(batch) < size {
+				continue
+			}
+
+			timer.Stop()
+			expired = nil
+			h.handleBatch(batch)
+			batch = batch[:0]
 
-		// Calculate processing time in milliseconds
-		processingTimeMs := time.Since(startTime).Milliseconds()
+		case <-expired:
+			expired = nil
+			h.handleBatch(batch)
+			batch = batch[:0]
 
-		logrus.WithFields(logrus.Fields{
-			"message_id":         messageID,
-			"processing_time_ms": processingTimeMs,
-			"topic":              message.Topic,
-			"partition":          message.Partition,
-			"offset":             message.Offset,
-		}).Info("Message processed")
+		case <-tick:
+			h.committer.tick()
 
-		// Mark the message as processed
-		session.MarkMessage(message, "")
+		case <-session.Context().Done():
+			// A rebalance handles the open batch before Cleanup commits, a shutdown
+			// leaves it for the next owner
+			if h.ctx.Err() == nil {
+				h.handleBatch(batch)
+			}
+			return nil
+		}
+	}
+}
+
+// handleBatch handles a batch of messages and commits the ones that were handled
+func (h *saramaConsumerGroupHandler) handleBatch(batch []*sarama.ConsumerMessage) {
+	if len(batch) == 0 {
+		return
+	}
+
+	msgs := make([]*Message, 0, len(batch))
+	for _, message := range batch {
+		msgs = append(msgs, messageFromSarama(message))
+	}
+
+	handled, _ := h.dispatcher.dispatchBatch(h.ctx, msgs)
+	if handled > 0 {
+		h.committer.handled(batch[:handled]...)
+	}
+}
+
+// saramaCommitter marks and commits the offsets of handled messages according to the commit mode.
+// It is safe for concurrent use by the claims and the workers.
+type saramaCommitter struct {
+	mu      sync.Mutex
+	session sarama.ConsumerGroupSession
+	mode    CommitMode
+	batch   *commitBatch
+}
+
+// newSaramaCommitter creates a committer for the session and the configured commit mode
+func newSaramaCommitter(session sarama.ConsumerGroupSession, config *ConsumerConfig) *saramaCommitter {
+	return &saramaCommitter{
+		session: session,
+		mode:    config.commitMode(),
+		batch:   newCommitBatch(config),
+	}
+}
+
+// handled marks the messages as processed and commits according to the mode
+func (c *saramaCommitter) handled(messages ...*sarama.ConsumerMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, message := range messages {
+		c.session.MarkMessage(message, "")
+	}
+
+	switch c.mode {
+	case CommitModeAfterSuccess:
+		c.session.Commit()
+	case CommitModeManualBatch:
+		if c.batch.add(len(messages)) {
+			c.flushLocked()
+		}
+	}
+}
+
+// tick commits marked offsets in manual batch mode once the interval has passed
+func (c *saramaCommitter) tick() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.batch.due() {
+		c.flushLocked()
+	}
+}
+
+// flush commits all marked offsets unless the client commits them itself
+func (c *saramaCommitter) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flushLocked()
+}
+
+// flushLocked is flush for callers already holding the lock
+func (c *saramaCommitter) flushLocked() {
+	if c.mode == CommitModeAuto {
+		return
+	}
+
+	c.session.Commit()
+	c.batch.reset()
+}
+
+// messageFromSarama converts a Sarama consumer message into a client-agnostic message
+func messageFromSarama(message *sarama.ConsumerMessage) *Message {
+	headers := make([]Header, 0, len(message.Headers))
+	for _, h := range message.Headers {
+		headers = append(headers, Header{Key: string(h.Key), Value: h.Value})
+	}
+
+	return &Message{
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   headers,
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Timestamp: message.Timestamp,
+	}
+}
+
+// saramaRecordPublisher publishes records through a dedicated Sarama sync producer
+type saramaRecordPublisher struct {
+	producer sarama.SyncProducer
+}
+
+// newSaramaRecordPublisher creates a sync producer waiting for all in-sync replicas
+func newSaramaRecordPublisher(brokers []string, security SecurityConfig) (*saramaRecordPublisher, error) {
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Return.Successes = true
+
+	if err := applySaramaSecurity(config, security); err != nil {
+		return nil, err
+	}
+
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &saramaRecordPublisher{producer: producer}, nil
+}
+
+// publish synchronously writes a record to the given topic
+func (p *saramaRecordPublisher) publish(ctx context.Context, topic string, key, value []byte, headers []Header) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, _, err := p.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.ByteEncoder(key),
+		Value:   sarama.ByteEncoder(value),
+		Headers: toSaramaHeaders(headers),
+	})
+	return err
+}
+
+// close shuts the producer down
+func (p *saramaRecordPublisher) close() {
+	if err := p.producer.Close(); err != nil {
+		logrus.WithError(err).Error("Error closing Sarama dead-letter producer")
 	}
-	return nil
 }
@@ -4,39 +4,32 @@ import (
 	"context"
 	"fmt"
 	"github.com/IBM/sarama"
-	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"github.com/xdg-go/scram"
+	"goEvents/internal/correlation"
+	"goEvents/internal/infrastructure/messaging/event"
 	"sync"
 	"time"
 )
 
 // SaramaKafkaProducer implements the MessageProducer interface using Sarama Kafka client
 type SaramaKafkaProducer struct {
+	client      sarama.Client
 	producer    sarama.SyncProducer
 	mutex       sync.Mutex
 	initialized bool
-	config      *sarama.Config
-	brokers     []string
-	topic       string
+	config      *ProducerConfig
 }
 
 // NewSaramaKafkaProducer creates a new Kafka producer using Sarama library
-func NewSaramaKafkaProducer(bootstrapServers string) *SaramaKafkaProducer {
-	if bootstrapServers == "" {
-		bootstrapServers = "localhost:9092"
+func NewSaramaKafkaProducer(config *ProducerConfig) *SaramaKafkaProducer {
+	if config == nil {
+		logrus.Fatal("Kafka configuration must be provided")
 	}
 
-	// Create producer configuration
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Retry.Max = 5
-	config.Producer.Return.Successes = true
-
 	return &SaramaKafkaProducer{
 		initialized: false,
 		config:      config,
-		brokers:     []string{bootstrapServers},
-		topic:       "orders",
 	}
 }
 
@@ -49,53 +42,107 @@ func (p *SaramaKafkaProducer) Initialize() error {
 		return nil
 	}
 
-	var err error
-	p.producer, err = sarama.NewSyncProducer(p.brokers, p.config)
+	config, err := saramaProducerConfig(p.config)
+	if err != nil {
+		return err
+	}
+
+	// The producer is created from a client so the brokers can be checked with the same connections
+	p.client, err = sarama.NewClient(p.config.brokers(), config)
 	if err != nil {
+		return fmt.Errorf("failed to create Sarama Kafka client: %w", err)
+	}
+
+	p.producer, err = sarama.NewSyncProducerFromClient(p.client)
+	if err != nil {
+		p.client.Close()
 		return fmt.Errorf("failed to create Sarama Kafka producer: %w", err)
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"brokers": p.brokers,
-		"topic":   p.topic,
+		"bootstrap_servers": p.config.BootstrapServers,
+		"topic":             p.config.Topic,
 	}).Info("Sarama Kafka producer initialized")
 
 	p.initialized = true
 	return nil
 }
 
-// PublishOrder publishes order messages to Kafka
-func (p *SaramaKafkaProducer) PublishOrder(orderID string) error {
+// PublishOrder publishes an order event and waits until the brokers acknowledged it
+func (p *SaramaKafkaProducer) PublishOrder(ctx context.Context, evt *event.OrderEvent) (err error) {
 	if err := p.Initialize(); err != nil {
 		return err
 	}
 
+	value, err := event.Encode(evt)
+	if err != nil {
+		return err
+	}
+
 	startTime := time.Now()
+	topic := p.config.Topic
 
-	// In a real-world scenario, you would likely not send 100,000 messages in a loop
-	// This is just to maintain the same behavior as the ConfluentKafkaProducer
-	for i := 0; i < 100000; i++ {
-		// Create a message
-		msg := &sarama.ProducerMessage{
-			Topic: p.topic,
-			Key:   sarama.StringEncoder(uuid.New().String()),
-			Value: sarama.StringEncoder(orderID),
-		}
+	ctx, span := startPublishSpan(ctx, topic)
+	defer func() { endSpan(span, err) }()
+
+	// Every record carries the correlation ID and the trace context of the span
+	msg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.ByteEncoder(evt.Key()),
+		Value:   sarama.ByteEncoder(value),
+		Headers: toSaramaHeaders(orderEventHeaders(ctx, evt)),
+	}
+
+	// The sync producer cannot be canceled, so a canceled context is only checked up front
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	partition, offset, err := p.producer.SendMessage(msg)
+	observeProduced("sarama", topic, time.Since(startTime), err)
+	if err != nil {
+		correlation.Logger(ctx).WithError(err).Error("Failed to send message with Sarama")
+		return err
+	}
 
-		// Send the message
-		_, _, err := p.producer.SendMessage(msg)
+	correlation.Logger(ctx).WithFields(logrus.Fields{
+		"event_id":   evt.EventID,
+		"event_type": evt.EventType,
+		"partition":  partition,
+		"offset":     offset,
+	}).Debug("Message sent with Sarama")
+
+	return nil
+}
+
+// Ping refreshes the metadata of the configured topic with the producer's client. Sarama
+// cannot cancel the refresh, so a refresh still running when ctx is done is abandoned.
+func (p *SaramaKafkaProducer) Ping(ctx context.Context) error {
+	p.mutex.Lock()
+	client := p.client
+	p.mutex.Unlock()
+
+	if client == nil {
+		return ErrProducerNotInitialized
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.RefreshMetadata(p.config.Topic)
+	}()
+
+	select {
+	case err := <-done:
 		if err != nil {
-			logrus.WithError(err).Error("Failed to send message with Sarama")
-			return err
+			return fmt.Errorf("failed to fetch metadata of topic %s: %w", p.config.Topic, err)
 		}
+	case <-ctx.Done():
+		return fmt.Errorf("failed to fetch metadata: %w", ctx.Err())
 	}
 
-	processingTimeMs := time.Since(startTime).Milliseconds()
-	logrus.WithFields(logrus.Fields{
-		"order_id":           orderID,
-		"processing_time_ms": processingTimeMs,
-	}).Info("Completed sending messages with Sarama")
-
+	if len(client.Brokers()) == 0 {
+		return errNoBrokers
+	}
 	return nil
 }
 
@@ -110,13 +157,18 @@ func (p *SaramaKafkaProducer) Shutdown(ctx context.Context) {
 
 	// Create a channel to signal when shutdown is complete
 	done := make(chan bool)
+	producer, client := p.producer, p.client
 
 	go func() {
-		if err := p.producer.Close(); err != nil {
+		if err := producer.Close(); err != nil {
 			logrus.WithError(err).Error("Error closing Sarama producer")
 		} else {
 			logrus.Info("Sarama producer closed successfully")
 		}
+		// A producer created from a client leaves closing the client to its owner
+		if err := client.Close(); err != nil {
+			logrus.WithError(err).Error("Error closing Sarama client")
+		}
 		close(done)
 	}()
 
@@ -130,4 +182,135 @@ func (p *SaramaKafkaProducer) Shutdown(ctx context.Context) {
 
 	p.initialized = false
 	p.producer = nil
+	p.client = nil
+}
+
+// saramaProducerConfig maps the producer configuration into a Sarama config
+func saramaProducerConfig(config *ProducerConfig) (*sarama.Config, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.Retry.Max = config.Retries
+	saramaConfig.Producer.MaxMessageBytes = config.batchMaxBytes()
+
+	// Sarama waits for a flush trigger once any is set, so a full batch must trigger too
+	if config.Linger > 0 {
+		saramaConfig.Producer.Flush.Frequency = config.Linger
+		saramaConfig.Producer.Flush.Bytes = config.batchMaxBytes()
+	}
+
+	switch config.acks() {
+	case AcksAll:
+		saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	case AcksLeader:
+		saramaConfig.Producer.RequiredAcks = sarama.WaitForLocal
+	case AcksNone:
+		saramaConfig.Producer.RequiredAcks = sarama.NoResponse
+	}
+
+	// Sarama only keeps records in order for idempotent writes with one request in flight
+	if config.Idempotent {
+		saramaConfig.Producer.Idempotent = true
+		saramaConfig.Net.MaxOpenRequests = 1
+	}
+
+	switch config.compression() {
+	case CompressionNone:
+		saramaConfig.Producer.Compression = sarama.CompressionNone
+	case CompressionGzip:
+		saramaConfig.Producer.Compression = sarama.CompressionGZIP
+	case CompressionSnappy:
+		saramaConfig.Producer.Compression = sarama.CompressionSnappy
+	case CompressionLz4:
+		saramaConfig.Producer.Compression = sarama.CompressionLZ4
+	case CompressionZstd:
+		saramaConfig.Producer.Compression = sarama.CompressionZSTD
+	}
+
+	switch config.partitioner() {
+	case PartitionerMurmur2:
+		// Sarama's default hashes with FNV-1a, the reference partitioner matches the Java client
+		saramaConfig.Producer.Partitioner = sarama.NewCustomPartitioner(
+			sarama.WithAbsFirst(),
+			sarama.WithCustomHashFunction(newMurmur2),
+		)
+	case PartitionerRandom:
+		saramaConfig.Producer.Partitioner = sarama.NewRandomPartitioner
+	}
+
+	if err := applySaramaSecurity(saramaConfig, config.Security); err != nil {
+		return nil, err
+	}
+
+	return saramaConfig, nil
+}
+
+// applySaramaSecurity maps the security configuration into the network settings of a Sarama config
+func applySaramaSecurity(config *sarama.Config, security SecurityConfig) error {
+	if err := security.Validate(); err != nil {
+		return err
+	}
+
+	tlsConfig, err := security.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if !security.SASL.enabled() {
+		return nil
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.User = security.SASL.Username
+	config.Net.SASL.Password = security.SASL.Password
+
+	switch security.SASL.Mechanism {
+	case SASLMechanismPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLMechanismScramSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &saramaSCRAMClient{hashGenerator: scram.SHA256}
+		}
+	case SASLMechanismScramSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &saramaSCRAMClient{hashGenerator: scram.SHA512}
+		}
+	}
+
+	return nil
+}
+
+// saramaSCRAMClient implements sarama.SCRAMClient, which Sarama leaves to the application
+type saramaSCRAMClient struct {
+	*scram.ClientConversation
+	hashGenerator scram.HashGeneratorFcn
+}
+
+// Begin starts a new SCRAM conversation for the given credentials
+func (c *saramaSCRAMClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.ClientConversation = client.NewConversation()
+	return nil
+}
+
+// toSaramaHeaders converts client-agnostic headers into Sarama record headers
+func toSaramaHeaders(headers []Header) []sarama.RecordHeader {
+	result := make([]sarama.RecordHeader, 0, len(headers))
+	for _, h := range headers {
+		result = append(result, sarama.RecordHeader{Key: []byte(h.Key), Value: h.Value})
+	}
+	return result
 }
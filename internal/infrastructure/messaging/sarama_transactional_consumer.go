@@ -0,0 +1,290 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SaramaTransactionalConsumer implements the MessageConsumer interface with exactly-once
+// semantics using Sarama's transactional producer. Each consumed message is transformed
+// and its output records and offset are committed in a single transaction.
+type SaramaTransactionalConsumer struct {
+	handler TransformHandler
+	config  *ConsumerConfig
+	wg      sync.WaitGroup
+	lag     lagRegistry
+	groups  groupMembership
+}
+
+// NewSaramaTransactionalConsumer creates a new transactional consumer with the given handler
+func NewSaramaTransactionalConsumer(handler TransformHandler, config *ConsumerConfig) *SaramaTransactionalConsumer {
+	if config == nil {
+		logrus.Fatal("Kafka configuration must be provided")
+	}
+	if config.TransactionalID == "" {
+		logrus.WithError(ErrMissingTransactionalID).Fatal("Invalid Kafka configuration")
+	}
+	if config.DeadLetterTopic == "" {
+		logrus.WithError(ErrMissingDeadLetterTopic).Fatal("Invalid Kafka configuration")
+	}
+	if err := config.Security.Validate(); err != nil {
+		logrus.WithError(err).Fatal("Invalid Kafka configuration")
+	}
+
+	return &SaramaTransactionalConsumer{
+		handler: handler,
+		config:  config,
+	}
+}
+
+// Start begins consuming messages in a goroutine
+func (c *SaramaTransactionalConsumer) Start(ctx context.Context) {
+	c.groups.expect(c.config.GroupID)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.consume(ctx)
+	}()
+}
+
+// Wait waits for all consumer goroutines to finish
+func (c *SaramaTransactionalConsumer) Wait() {
+	c.wg.Wait()
+}
+
+// Lag returns the lag of the partitions assigned to the consumer
+func (c *SaramaTransactionalConsumer) Lag(ctx context.Context) ([]PartitionLag, error) {
+	return c.lag.lag(ctx)
+}
+
+// Groups reports whether the consumer is a member of its group
+func (c *SaramaTransactionalConsumer) Groups() map[string]bool {
+	return c.groups.groups()
+}
+
+// consume handles the actual message consumption
+func (c *SaramaTransactionalConsumer) consume(ctx context.Context) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_5_0_0
+	config.Consumer.Return.Errors = true
+
+	// Only read records from committed transactions, offsets are committed by the transactions
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	// Set initial offset based on configuration
+	if c.config.AutoOffsetReset == "earliest" {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	} else {
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	}
+
+	if err := applySaramaSecurity(config, c.config.Security); err != nil {
+		logrus.WithError(err).Fatal("Invalid Sarama security configuration")
+		return
+	}
+
+	// The group is created from a client so the lag can be queried with the same connections
+	saramaClient, err := sarama.NewClient(c.config.brokers(), config)
+	if err != nil {
+		logrus.WithError(err).Fatal("Error creating Sarama client")
+		return
+	}
+	defer func() {
+		if err := saramaClient.Close(); err != nil {
+			logrus.WithError(err).Error("Error closing Sarama client")
+		}
+	}()
+
+	client, err := sarama.NewConsumerGroupFromClient(c.config.GroupID, saramaClient)
+	if err != nil {
+		logrus.WithError(err).Fatal("Error creating Sarama consumer group")
+		return
+	}
+	defer func() {
+		if err := client.Close(); err != nil {
+			logrus.WithError(err).Error("Error closing Sarama consumer group")
+		}
+	}()
+
+	// Track errors from the consumer group
+	go func() {
+		for err := range client.Errors() {
+			logrus.WithError(err).Error("Error from Sarama consumer")
+		}
+	}()
+
+	handler := &saramaTransactionalHandler{
+		handler:    c.handler,
+		config:     c.config,
+		assignment: newAssignedPartitions(),
+	}
+
+	c.lag.register(c.config.GroupID, func(ctx context.Context) ([]PartitionLag, error) {
+		return saramaLag(ctx, saramaClient, c.config.GroupID, handler.assignment.snapshot())
+	})
+	defer c.lag.unregister(c.config.GroupID)
+
+	c.groups.track(c.config.GroupID, handler.joined.Load)
+	defer c.groups.track(c.config.GroupID, nil)
+
+	logrus.WithFields(logrus.Fields{
+		"bootstrap_servers": c.config.BootstrapServers,
+		"group_id":          c.config.GroupID,
+		"topics":            c.config.Topics,
+		"transactional_id":  c.config.TransactionalID,
+	}).Info("Sarama transactional consumer started and waiting for messages")
+
+	for {
+		if err := client.Consume(ctx, c.config.Topics, handler); err != nil {
+			logrus.WithError(err).Error("Error from consumer")
+		}
+
+		// Check if context was canceled, indicating shutdown
+		if ctx.Err() != nil {
+			logrus.Info("Context canceled, stopping Sarama transactional consumer")
+			return
+		}
+	}
+}
+
+// saramaTransactionalHandler implements the sarama.ConsumerGroupHandler interface
+type saramaTransactionalHandler struct {
+	handler    TransformHandler
+	config     *ConsumerConfig
+	assignment *assignedPartitions
+	// joined is set while a session runs
+	joined atomic.Bool
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim
+func (h *saramaTransactionalHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.assignment.add(session.Claims())
+	h.joined.Store(true)
+	return nil
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
+func (h *saramaTransactionalHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	h.assignment.remove(session.Claims())
+	h.joined.Store(false)
+	return nil
+}
+
+// ConsumeClaim processes each message of the claim in its own transaction. The producer
+// is created per partition so a new owner of the partition fences off the previous one.
+func (h *saramaTransactionalHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	producer, err := newSaramaTransactionalProducer(h.config, claim.Topic(), claim.Partition())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := producer.Close(); err != nil {
+			logrus.WithError(err).Error("Error closing Sarama transactional producer")
+		}
+	}()
+
+	ctx := session.Context()
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+
+			// Retry the message in place, the offset only moves with a committed transaction
+			backoff := 100 * time.Millisecond
+			for {
+				err := h.processMessage(ctx, producer, message)
+				if err == nil {
+					break
+				}
+
+				if producer.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0 {
+					// The producer was fenced or broke, give the partition up
+					return err
+				}
+
+				logrus.WithError(err).WithFields(logrus.Fields{
+					"topic":     message.Topic,
+					"partition": message.Partition,
+					"offset":    message.Offset,
+					"backoff":   backoff.String(),
+				}).Warn("Transaction aborted, message will be reprocessed")
+
+				sleepContext(ctx, backoff)
+				if ctx.Err() != nil {
+					return nil
+				}
+				backoff = nextBackoff(backoff)
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// processMessage transforms a message and commits its outputs and offset atomically
+func (h *saramaTransactionalHandler) processMessage(ctx context.Context, producer sarama.SyncProducer, message *sarama.ConsumerMessage) error {
+	outputs := transform(ctx, "sarama", h.handler, h.config, messageFromSarama(message))
+
+	if err := producer.BeginTxn(); err != nil {
+		return err
+	}
+
+	if err := h.produceInTxn(producer, message, outputs); err != nil {
+		if abortErr := producer.AbortTxn(); abortErr != nil {
+			logrus.WithError(abortErr).Error("Failed to abort Sarama transaction")
+		}
+		return err
+	}
+
+	if err := producer.CommitTxn(); err != nil {
+		if abortErr := producer.AbortTxn(); abortErr != nil {
+			logrus.WithError(abortErr).Error("Failed to abort Sarama transaction")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// produceInTxn sends the output records and adds the consumed offset to the open transaction
+func (h *saramaTransactionalHandler) produceInTxn(producer sarama.SyncProducer, message *sarama.ConsumerMessage, outputs []*Message) error {
+	for _, out := range outputs {
+		_, _, err := producer.SendMessage(&sarama.ProducerMessage{
+			Topic:   out.Topic,
+			Key:     sarama.ByteEncoder(out.Key),
+			Value:   sarama.ByteEncoder(out.Value),
+			Headers: toSaramaHeaders(out.Headers),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return producer.AddMessageToTxn(message, h.config.GroupID, nil)
+}
+
+// newSaramaTransactionalProducer creates a transactional producer for one consumed partition
+func newSaramaTransactionalProducer(config *ConsumerConfig, topic string, partition int32) (sarama.SyncProducer, error) {
+	producerConfig := sarama.NewConfig()
+	producerConfig.Version = sarama.V2_5_0_0
+	producerConfig.Producer.Idempotent = true
+	producerConfig.Producer.RequiredAcks = sarama.WaitForAll
+	producerConfig.Producer.Return.Successes = true
+	producerConfig.Producer.Transaction.ID = fmt.Sprintf("%s-%s-%d", config.TransactionalID, topic, partition)
+	producerConfig.Net.MaxOpenRequests = 1
+
+	if err := applySaramaSecurity(producerConfig, config.Security); err != nil {
+		return nil, err
+	}
+
+	return sarama.NewSyncProducer(config.brokers(), producerConfig)
+}
@@ -0,0 +1,134 @@
+package messaging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SASLMechanism selects how clients authenticate to the brokers
+type SASLMechanism string
+
+const (
+	// SASLMechanismPlain sends the username and password in clear text, use it with TLS only
+	SASLMechanismPlain SASLMechanism = "PLAIN"
+	// SASLMechanismScramSHA256 authenticates with a SCRAM challenge using SHA-256
+	SASLMechanismScramSHA256 SASLMechanism = "SCRAM-SHA-256"
+	// SASLMechanismScramSHA512 authenticates with a SCRAM challenge using SHA-512
+	SASLMechanismScramSHA512 SASLMechanism = "SCRAM-SHA-512"
+)
+
+// ErrInvalidSecurityConfig is returned when a SecurityConfig cannot be applied to a client
+var ErrInvalidSecurityConfig = errors.New("invalid Kafka security configuration")
+
+// SecurityConfig holds the connection security shared by producers and consumers.
+// The zero value connects in plain text without authentication.
+type SecurityConfig struct {
+	TLS  TLSConfig
+	SASL SASLConfig
+}
+
+// TLSConfig encrypts the connections to the brokers
+type TLSConfig struct {
+	// Enabled turns TLS on, it is also implied by any of the files below
+	Enabled bool
+	// CAFile is a PEM bundle of the CAs trusted to sign the broker certificates,
+	// the system pool is used when empty
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables the verification of the broker certificates
+	InsecureSkipVerify bool
+}
+
+// SASLConfig authenticates the connections to the brokers
+type SASLConfig struct {
+	// Mechanism enables SASL, empty disables it
+	Mechanism SASLMechanism
+	Username  string
+	Password  string
+}
+
+// enabled reports whether TLS is used
+func (c TLSConfig) enabled() bool {
+	return c.Enabled || c.CAFile != "" || c.CertFile != "" || c.KeyFile != ""
+}
+
+// enabled reports whether SASL is used
+func (c SASLConfig) enabled() bool {
+	return c.Mechanism != ""
+}
+
+// Validate checks that the configuration is complete and uses a supported mechanism
+func (c SecurityConfig) Validate() error {
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("%w: TLS CertFile and KeyFile must be set together", ErrInvalidSecurityConfig)
+	}
+
+	if !c.SASL.enabled() {
+		return nil
+	}
+
+	switch c.SASL.Mechanism {
+	case SASLMechanismPlain, SASLMechanismScramSHA256, SASLMechanismScramSHA512:
+	default:
+		return fmt.Errorf("%w: unsupported SASL mechanism %q", ErrInvalidSecurityConfig, c.SASL.Mechanism)
+	}
+
+	if c.SASL.Username == "" || c.SASL.Password == "" {
+		return fmt.Errorf("%w: SASL %s requires a username and a password", ErrInvalidSecurityConfig, c.SASL.Mechanism)
+	}
+
+	return nil
+}
+
+// protocol returns the Kafka security.protocol matching the configuration
+func (c SecurityConfig) protocol() string {
+	switch {
+	case c.TLS.enabled() && c.SASL.enabled():
+		return "SASL_SSL"
+	case c.TLS.enabled():
+		return "SSL"
+	case c.SASL.enabled():
+		return "SASL_PLAINTEXT"
+	default:
+		return "PLAINTEXT"
+	}
+}
+
+// tlsConfig loads the CA and client certificate files, it returns nil when TLS is disabled
+func (c SecurityConfig) tlsConfig() (*tls.Config, error) {
+	if !c.TLS.enabled() {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+	}
+
+	if c.TLS.CAFile != "" {
+		pem, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates found in CA file %s", ErrInvalidSecurityConfig, c.TLS.CAFile)
+		}
+	}
+
+	if c.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,84 @@
+package messaging
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"goEvents/internal/correlation"
+	"goEvents/internal/telemetry"
+	"strconv"
+)
+
+// startPublishSpan starts a producer span for the record of one publish call.
+// The trace context of the span is written to the record headers.
+func startPublishSpan(ctx context.Context, topic string) (context.Context, trace.Span) {
+	return telemetry.Tracer().Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(topic),
+		),
+	)
+}
+
+// startProcessSpan starts a consumer span for a message, continuing the trace of its producer
+func startProcessSpan(ctx context.Context, msg *Message) (context.Context, trace.Span) {
+	if traceParent, ok := msg.Header(correlation.TraceParentHeader); ok {
+		ctx = correlation.WithRemoteParent(ctx, traceParent)
+	}
+
+	return telemetry.Tracer().Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messageAttributes(msg)...),
+	)
+}
+
+// startBatchProcessSpan starts a consumer span for a batch. A batch mixes records of
+// several traces, so the span starts a new trace linked to the trace of every record.
+func startBatchProcessSpan(ctx context.Context, msgs []*Message) (context.Context, trace.Span) {
+	links := make([]trace.Link, 0, len(msgs))
+	for _, msg := range msgs {
+		traceParent, ok := msg.Header(correlation.TraceParentHeader)
+		if !ok {
+			continue
+		}
+		if sc := trace.SpanContextFromContext(correlation.WithRemoteParent(ctx, traceParent)); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	return telemetry.Tracer().Start(ctx, msgs[0].Topic+" process",
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(msgs[0].Topic),
+			semconv.MessagingBatchMessageCount(len(msgs)),
+		),
+	)
+}
+
+// messageAttributes returns the span attributes describing a consumed message
+func messageAttributes(msg *Message) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKafka,
+		semconv.MessagingOperationTypeDeliver,
+		semconv.MessagingDestinationName(msg.Topic),
+		semconv.MessagingDestinationPartitionID(strconv.Itoa(int(msg.Partition))),
+		semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+	}
+}
+
+// endSpan records the error of the operation, if any, and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,279 @@
+package messaging
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/IBM/sarama"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"goEvents/internal/correlation"
+	"goEvents/internal/domain/model"
+	"goEvents/internal/infrastructure/messaging/event"
+	"goEvents/internal/telemetry"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+const tracingTestTopic = "orders"
+
+// newTracingTestExporter installs a tracer provider recording every span in memory
+// until the test ends. The provider is global, so tracing tests do not run in parallel.
+func newTracingTestExporter(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := telemetry.NewTracerProvider("test", sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// newTracingTestCluster starts an in-memory cluster with the test topic, closed when the test ends
+func newTracingTestCluster(t *testing.T) (string, *kgo.Client) {
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(1, tracingTestTopic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	// Sarama and librdkafka write a partition leader epoch of 0 in produced batches, which
+	// the in-memory cluster rejects. The field precedes the checksummed bytes of the batch.
+	cluster.ControlKey(int16(kmsg.Produce), func(req kmsg.Request) (kmsg.Response, error, bool) {
+		for _, topic := range req.(*kmsg.ProduceRequest).Topics {
+			for _, partition := range topic.Partitions {
+				if len(partition.Records) >= 16 {
+					binary.BigEndian.PutUint32(partition.Records[12:16], math.MaxUint32)
+				}
+			}
+		}
+		return nil, nil, false
+	})
+
+	bootstrapServers := cluster.ListenAddrs()[0]
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(bootstrapServers),
+		kgo.ConsumeTopics(tracingTestTopic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return bootstrapServers, client
+}
+
+// findSpan returns the single recorded span with the given name
+func findSpan(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+
+	var found []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			found = append(found, span)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("recorded %d spans named %q, want 1", len(found), name)
+	}
+	return found[0]
+}
+
+// tracingTestProducers creates the producer of every client
+var tracingTestProducers = map[string]func(config *ProducerConfig) MessageProducer{
+	"franz":     func(config *ProducerConfig) MessageProducer { return NewFranzKafkaProducer(config) },
+	"sarama":    func(config *ProducerConfig) MessageProducer { return NewSaramaKafkaProducer(config) },
+	"confluent": func(config *ProducerConfig) MessageProducer { return NewConfluentKafkaProducer(config) },
+}
+
+// TestPublishInjectsTraceParent publishes an event in a span and checks that each client
+// records a publish span below it and writes the trace context of that span to the record
+func TestPublishInjectsTraceParent(t *testing.T) {
+	for client, newProducer := range tracingTestProducers {
+		t.Run(client, func(t *testing.T) {
+			exporter := newTracingTestExporter(t)
+			bootstrapServers, consumer := newTracingTestCluster(t)
+
+			config := DefaultProducerConfig()
+			config.BootstrapServers = bootstrapServers
+			config.Topic = tracingTestTopic
+			producer := newProducer(&config)
+			defer producer.Shutdown(context.Background())
+
+			ctx, parent := telemetry.Tracer().Start(context.Background(), "test")
+			evt := event.NewOrderEvent(event.TypeOrderCreated, &model.Order{ID: 1, Description: "traced", Quantity: 1})
+			if err := producer.PublishOrder(ctx, evt); err != nil {
+				t.Fatal(err)
+			}
+			parent.End()
+
+			publish := findSpan(t, exporter, tracingTestTopic+" publish")
+			if publish.SpanKind != trace.SpanKindProducer {
+				t.Errorf("publish span kind = %v, want producer", publish.SpanKind)
+			}
+			if publish.Parent.SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("publish span parent = %s, want the span of the caller %s", publish.Parent.SpanID(), parent.SpanContext().SpanID())
+			}
+
+			fetchCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			fetches := consumer.PollRecords(fetchCtx, 1)
+			if err := fetches.Err(); err != nil {
+				t.Fatal(err)
+			}
+			records := fetches.Records()
+			if len(records) != 1 {
+				t.Fatalf("fetched %d records, want 1", len(records))
+			}
+
+			want := correlation.TraceParent{
+				TraceID: publish.SpanContext.TraceID(),
+				SpanID:  publish.SpanContext.SpanID(),
+				Flags:   byte(publish.SpanContext.TraceFlags()),
+			}.String()
+			var got string
+			for _, header := range records[0].Headers {
+				if header.Key == correlation.TraceParentHeader {
+					got = string(header.Value)
+				}
+			}
+			if got != want {
+				t.Errorf("traceparent header = %q, want the publish span %q", got, want)
+			}
+		})
+	}
+}
+
+// tracingTestConsumers delivers one record with the given headers to the consumer of
+// every client and returns once the handler has been called
+var tracingTestConsumers = map[string]func(t *testing.T, headers []Header, handler MessageHandler){
+	"franz": func(t *testing.T, headers []Header, handler MessageHandler) {
+		consumeTracingTestRecord(t, headers, handler, func(handler MessageHandler, config *ConsumerConfig) MessageConsumer {
+			return NewFranzKafkaConsumer(handler, config)
+		})
+	},
+	"confluent": func(t *testing.T, headers []Header, handler MessageHandler) {
+		consumeTracingTestRecord(t, headers, handler, func(handler MessageHandler, config *ConsumerConfig) MessageConsumer {
+			return NewConfluentKafkaConsumer(handler, config)
+		})
+	},
+	"sarama": func(t *testing.T, headers []Header, handler MessageHandler) {
+		config := &ConsumerConfig{GroupID: "tracing-test", Topics: []string{tracingTestTopic}}
+		processor := newMessageProcessor(handler, config, "sarama", nil)
+		defer processor.close()
+		groupHandler := &saramaConsumerGroupHandler{
+			ctx:        context.Background(),
+			processor:  processor,
+			config:     config,
+			groupID:    config.GroupID,
+			assignment: newAssignedPartitions(),
+		}
+
+		session := &saramaTestSession{ctx: context.Background(), group: &saramaTestGroup{}}
+		claim := &saramaTestClaim{messages: make(chan *sarama.ConsumerMessage, 1)}
+		msg := &sarama.ConsumerMessage{Topic: tracingTestTopic, Value: []byte("traced")}
+		for _, header := range toSaramaHeaders(headers) {
+			msg.Headers = append(msg.Headers, &header)
+		}
+		claim.messages <- msg
+		close(claim.messages)
+
+		if err := groupHandler.Setup(session); err != nil {
+			t.Fatal(err)
+		}
+		if err := groupHandler.ConsumeClaim(session, claim); err != nil {
+			t.Fatal(err)
+		}
+		_ = groupHandler.Cleanup(session)
+	},
+}
+
+// consumeTracingTestRecord produces one record to an in-memory cluster and runs the
+// consumer until its handler was called
+func consumeTracingTestRecord(t *testing.T, headers []Header, handler MessageHandler, newConsumer func(handler MessageHandler, config *ConsumerConfig) MessageConsumer) {
+	bootstrapServers, client := newTracingTestCluster(t)
+
+	record := &kgo.Record{Topic: tracingTestTopic, Value: []byte("traced"), Headers: toFranzHeaders(headers)}
+	if err := client.ProduceSync(context.Background(), record).FirstErr(); err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan struct{})
+	var once sync.Once
+	signal := MessageHandlerFunc(func(ctx context.Context, msg *Message) error {
+		defer once.Do(func() { close(handled) })
+		return handler.Handle(ctx, msg)
+	})
+	consumer := newConsumer(signal, &ConsumerConfig{
+		BootstrapServers: bootstrapServers,
+		GroupID:          "tracing-test",
+		Topics:           []string{tracingTestTopic},
+		AutoOffsetReset:  "earliest",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer.Start(ctx)
+	defer consumer.Wait()
+	defer cancel()
+
+	waitFor(t, "the record to be handled", func() bool {
+		select {
+		case <-handled:
+			return true
+		default:
+			return false
+		}
+	})
+}
+
+// TestProcessSpanContinuesProducerTrace consumes a record carrying the trace context of a
+// publish span and checks that each client handles it in a consumer span below that span
+func TestProcessSpanContinuesProducerTrace(t *testing.T) {
+	for client, consume := range tracingTestConsumers {
+		t.Run(client, func(t *testing.T) {
+			exporter := newTracingTestExporter(t)
+
+			// The publish span stands for the producer of another service
+			ctx, publish := startPublishSpan(context.Background(), tracingTestTopic)
+			headers := correlationHeaders(ctx)
+			publish.End()
+
+			var mu sync.Mutex
+			var handlerSpan trace.SpanContext
+			consume(t, headers, MessageHandlerFunc(func(ctx context.Context, _ *Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				handlerSpan = trace.SpanContextFromContext(ctx)
+				return nil
+			}))
+
+			process := findSpan(t, exporter, tracingTestTopic+" process")
+			if process.SpanKind != trace.SpanKindConsumer {
+				t.Errorf("process span kind = %v, want consumer", process.SpanKind)
+			}
+			if !process.Parent.IsRemote() {
+				t.Error("process span parent is not remote")
+			}
+			if process.Parent.TraceID() != publish.SpanContext().TraceID() || process.Parent.SpanID() != publish.SpanContext().SpanID() {
+				t.Errorf("process span parent = %s/%s, want the publish span %s/%s",
+					process.Parent.TraceID(), process.Parent.SpanID(),
+					publish.SpanContext().TraceID(), publish.SpanContext().SpanID())
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if handlerSpan.SpanID() != process.SpanContext.SpanID() {
+				t.Errorf("handler ran in span %s, want the process span %s", handlerSpan.SpanID(), process.SpanContext.SpanID())
+			}
+		})
+	}
+}
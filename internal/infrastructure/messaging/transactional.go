@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"goEvents/internal/correlation"
+	"time"
+)
+
+var (
+	// ErrMissingTransactionalID is returned when a transactional consumer has no TransactionalID configured
+	ErrMissingTransactionalID = errors.New("transactional consumer requires a TransactionalID")
+	// ErrMissingDeadLetterTopic is returned when a transactional consumer has no DeadLetterTopic configured
+	ErrMissingDeadLetterTopic = errors.New("transactional consumer requires a DeadLetterTopic")
+)
+
+// TransformHandler processes a consumed message and returns the records to produce.
+// The transactional consumers produce the returned records and commit the consumed
+// offset in one Kafka transaction, giving exactly-once consume-transform-produce.
+// Only the Topic, Key, Value and Headers of the returned messages are used.
+type TransformHandler interface {
+	Transform(ctx context.Context, msg *Message) ([]*Message, error)
+}
+
+// TransformHandlerFunc adapts a function to the TransformHandler interface
+type TransformHandlerFunc func(ctx context.Context, msg *Message) ([]*Message, error)
+
+// Transform calls f(ctx, msg)
+func (f TransformHandlerFunc) Transform(ctx context.Context, msg *Message) ([]*Message, error) {
+	return f(ctx, msg)
+}
+
+// transform runs the handler for a message inside a transaction. When the handler
+// fails or returns an output record without a topic, the message is dead-lettered as
+// part of the same transaction instead, so a poison message cannot block the partition.
+// Only a failed transaction is retried, which makes the dead-letter topic mandatory.
+// Output records without a correlation ID inherit the one of the consumed message.
+func transform(ctx context.Context, client string, handler TransformHandler, config *ConsumerConfig, msg *Message) []*Message {
+	ctx, span := startProcessSpan(ctx, msg)
+	defer span.End()
+
+	ctx = messageContext(ctx, msg)
+
+	startTime := time.Now()
+	outputs, err := handler.Transform(ctx, msg)
+	if err == nil {
+		err = checkOutputs(msg, outputs)
+	}
+	observeHandled(client, msg.Topic, time.Since(startTime), err)
+	if err == nil {
+		for _, out := range outputs {
+			if _, ok := out.Header(correlation.KafkaHeader); !ok {
+				out.Headers = append(out.Headers, correlationHeaders(ctx)...)
+			}
+		}
+		return outputs
+	}
+
+	span.RecordError(err)
+	correlation.Logger(ctx).WithError(err).WithFields(logrus.Fields{
+		"dead_letter_topic": config.DeadLetterTopic,
+		"topic":             msg.Topic,
+		"partition":         msg.Partition,
+		"offset":            msg.Offset,
+	}).Warn("Transform failed, dead-lettering message in the transaction")
+
+	return []*Message{{
+		Topic:   config.DeadLetterTopic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: failureHeaders(msg, err, time.Now()),
+	}}
+}
+
+// checkOutputs rejects output records that cannot be produced
+func checkOutputs(msg *Message, outputs []*Message) error {
+	for _, out := range outputs {
+		if out.Topic == "" {
+			return fmt.Errorf("output record for %s/%d@%d has no topic", msg.Topic, msg.Partition, msg.Offset)
+		}
+	}
+	return nil
+}
+
+// nextBackoff doubles the backoff up to maxRetryBackoff
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// sleepContext waits for the duration or until the context is canceled
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
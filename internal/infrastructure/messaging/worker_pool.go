@@ -0,0 +1,265 @@
+package messaging
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// workerLaneBuffer is the number of records queued per worker before dispatching blocks
+const workerLaneBuffer = 64
+
+// dispatcher hands consumed messages to the processor, either inline in the consume
+// loop or through a pool of worker lanes. A lane is chosen by hashing the record key,
+// so records with the same key are processed in order by the same worker.
+type dispatcher struct {
+	processor *messageProcessor
+	flow      *flowController
+	lanes     []chan dispatchTask
+	tracker   *offsetTracker
+	wg        sync.WaitGroup
+
+	// queued counts the records on the lanes so a rebalance can wait for them
+	mu     sync.Mutex
+	idle   *sync.Cond
+	queued int
+}
+
+// dispatchTask is a message queued on a worker lane
+type dispatchTask struct {
+	ctx   context.Context
+	msg   *Message
+	entry *trackedOffset
+}
+
+// newDispatcher creates a dispatcher with the configured number of workers, reporting
+// in-flight records and handler latency to the flow controller if one is given
+func newDispatcher(processor *messageProcessor, config *ConsumerConfig, flow *flowController) *dispatcher {
+	d := &dispatcher{
+		processor: processor,
+		flow:      flow,
+	}
+	d.idle = sync.NewCond(&d.mu)
+
+	if config.Workers <= 1 {
+		return d
+	}
+
+	d.tracker = newOffsetTracker()
+	d.lanes = make([]chan dispatchTask, config.Workers)
+	for i := range d.lanes {
+		d.lanes[i] = make(chan dispatchTask, workerLaneBuffer)
+		d.wg.Add(1)
+		go d.run(d.lanes[i])
+	}
+
+	return d
+}
+
+// dispatch processes a message. The commit function is called once the message and
+// every earlier message of its partition were handled, so offsets are only committed
+// up to the lowest completed offset per partition. Without workers the message is
+// processed before dispatch returns; with workers it is queued on its lane and an
+// error is only returned if the context is canceled while waiting for room.
+func (d *dispatcher) dispatch(ctx context.Context, msg *Message, commit func()) error {
+	d.flow.started(1)
+
+	if d.lanes == nil {
+		if err := d.handle(ctx, msg); err != nil {
+			return err
+		}
+		commit()
+		return nil
+	}
+
+	task := dispatchTask{
+		ctx:   ctx,
+		msg:   msg,
+		entry: d.tracker.track(msg, commit),
+	}
+
+	d.addQueued(1)
+	select {
+	case d.lanes[laneIndex(msg, len(d.lanes))] <- task:
+		return nil
+	case <-ctx.Done():
+		d.addQueued(-1)
+		d.flow.dropped(1)
+		return ctx.Err()
+	}
+}
+
+// dispatchBatch handles a batch in the consume loop, see messageProcessor.handleBatch
+func (d *dispatcher) dispatchBatch(ctx context.Context, msgs []*Message) (int, error) {
+	d.flow.started(len(msgs))
+
+	startTime := time.Now()
+	handled, err := d.processor.handleBatch(ctx, msgs)
+	d.flow.finished(len(msgs), time.Since(startTime))
+
+	return handled, err
+}
+
+// batching reports whether messages should be gathered into batches
+func (d *dispatcher) batching() bool {
+	return d.processor.batching()
+}
+
+// handle processes one message and reports its latency to the flow controller
+func (d *dispatcher) handle(ctx context.Context, msg *Message) error {
+	startTime := time.Now()
+	err := d.processor.handle(ctx, msg)
+	d.flow.finished(1, time.Since(startTime))
+	return err
+}
+
+// run processes the tasks of one lane in order
+func (d *dispatcher) run(lane chan dispatchTask) {
+	defer d.wg.Done()
+
+	for task := range lane {
+		d.runTask(task)
+		d.addQueued(-1)
+	}
+}
+
+// runTask handles a queued message and completes its offset
+func (d *dispatcher) runTask(task dispatchTask) {
+	// Queued records are left for redelivery once the consumer shuts down
+	if task.ctx.Err() != nil {
+		d.flow.dropped(1)
+		return
+	}
+
+	// A record that could not be handled holds back its partition's committed offset,
+	// except in auto mode which moves past failed records
+	err := d.handle(task.ctx, task.msg)
+	if err != nil && d.processor.commitMode != CommitModeAuto {
+		return
+	}
+
+	d.tracker.complete(task.msg, task.entry)
+}
+
+// addQueued changes the number of queued records, waking drain once there are none
+func (d *dispatcher) addQueued(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.queued += n
+	if d.queued == 0 {
+		d.idle.Broadcast()
+	}
+}
+
+// drain waits until the workers handled every queued record, so their offsets can be
+// committed before partitions are revoked. Records that could not be handled are
+// forgotten as they will be redelivered to the next owner.
+func (d *dispatcher) drain() {
+	if d.lanes == nil {
+		return
+	}
+
+	d.mu.Lock()
+	for d.queued > 0 {
+		d.idle.Wait()
+	}
+	d.mu.Unlock()
+
+	d.tracker.reset()
+}
+
+// close stops the workers once the queued records were processed or skipped
+func (d *dispatcher) close() {
+	for _, lane := range d.lanes {
+		close(lane)
+	}
+	d.wg.Wait()
+}
+
+// laneIndex hashes the record key to a lane, keyless records are spread by partition
+func laneIndex(msg *Message, lanes int) int {
+	h := fnv.New32a()
+	if len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		h.Write([]byte(msg.Topic + "/" + strconv.Itoa(int(msg.Partition))))
+	}
+	return int(h.Sum32() % uint32(lanes))
+}
+
+// topicPartition identifies a partition of a topic
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// trackedOffset is a dispatched record waiting for its commit
+type trackedOffset struct {
+	offset int64
+	done   bool
+	commit func()
+}
+
+// offsetTracker keeps the dispatched records of each partition in offset order and
+// commits the highest offset below which every record has completed
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition][]*trackedOffset
+}
+
+// newOffsetTracker creates an empty tracker
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[topicPartition][]*trackedOffset),
+	}
+}
+
+// track registers a dispatched record, records of a partition must be tracked in offset order
+func (t *offsetTracker) track(msg *Message, commit func()) *trackedOffset {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := &trackedOffset{offset: msg.Offset, commit: commit}
+	key := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	t.partitions[key] = append(t.partitions[key], entry)
+	return entry
+}
+
+// reset forgets every tracked record
+func (t *offsetTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partitions = make(map[topicPartition][]*trackedOffset)
+}
+
+// complete marks a record as handled and commits the partition's new low watermark.
+// Commits are made while holding the lock so they never go backwards.
+func (t *offsetTracker) complete(msg *Message, entry *trackedOffset) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry.done = true
+
+	key := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	pending := t.partitions[key]
+
+	var committable *trackedOffset
+	for len(pending) > 0 && pending[0].done {
+		committable = pending[0]
+		pending = pending[1:]
+	}
+
+	if len(pending) == 0 {
+		delete(t.partitions, key)
+	} else {
+		t.partitions[key] = pending
+	}
+
+	if committable != nil {
+		committable.commit()
+	}
+}
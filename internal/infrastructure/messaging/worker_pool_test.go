@@ -0,0 +1,110 @@
+package messaging
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOffsetTrackerCommitsLowWatermark(t *testing.T) {
+	type step struct {
+		partition int32
+		offset    int64
+	}
+
+	tests := []struct {
+		name     string
+		tracked  []step
+		complete []step
+		// want lists the commits made per partition, in order
+		want map[int32][]int64
+	}{
+		{
+			name:     "in order",
+			tracked:  []step{{0, 0}, {0, 1}, {0, 2}},
+			complete: []step{{0, 0}, {0, 1}, {0, 2}},
+			want:     map[int32][]int64{0: {0, 1, 2}},
+		},
+		{
+			name:     "later lane finishes first",
+			tracked:  []step{{0, 0}, {0, 1}, {0, 2}},
+			complete: []step{{0, 2}, {0, 1}, {0, 0}},
+			want:     map[int32][]int64{0: {2}},
+		},
+		{
+			name:     "gap holds back the commit",
+			tracked:  []step{{0, 0}, {0, 1}, {0, 2}, {0, 3}},
+			complete: []step{{0, 0}, {0, 2}, {0, 3}},
+			want:     map[int32][]int64{0: {0}},
+		},
+		{
+			name:     "filling the gap commits everything behind it",
+			tracked:  []step{{0, 0}, {0, 1}, {0, 2}, {0, 3}, {0, 4}},
+			complete: []step{{0, 1}, {0, 3}, {0, 0}, {0, 4}, {0, 2}},
+			want:     map[int32][]int64{0: {1, 4}},
+		},
+		{
+			name:     "sparse offsets after compaction",
+			tracked:  []step{{0, 10}, {0, 14}, {0, 20}},
+			complete: []step{{0, 14}, {0, 10}, {0, 20}},
+			want:     map[int32][]int64{0: {14, 20}},
+		},
+		{
+			name:     "partitions are independent",
+			tracked:  []step{{0, 0}, {1, 0}, {0, 1}, {1, 1}},
+			complete: []step{{1, 1}, {0, 0}, {0, 1}, {1, 0}},
+			want:     map[int32][]int64{0: {0, 1}, 1: {1}},
+		},
+		{
+			name:     "blocked partition does not hold back another",
+			tracked:  []step{{0, 0}, {0, 1}, {1, 5}, {1, 6}},
+			complete: []step{{0, 1}, {1, 5}, {1, 6}},
+			want:     map[int32][]int64{1: {5, 6}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newOffsetTracker()
+			commits := make(map[int32][]int64)
+			entries := make(map[step]*trackedOffset)
+
+			for _, s := range tt.tracked {
+				s := s
+				msg := &Message{Topic: "orders", Partition: s.partition, Offset: s.offset}
+				entries[s] = tracker.track(msg, func() {
+					commits[s.partition] = append(commits[s.partition], s.offset)
+				})
+			}
+
+			for _, s := range tt.complete {
+				msg := &Message{Topic: "orders", Partition: s.partition, Offset: s.offset}
+				tracker.complete(msg, entries[s])
+			}
+
+			if !reflect.DeepEqual(commits, tt.want) {
+				t.Errorf("commits = %v, want %v", commits, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffsetTrackerReset(t *testing.T) {
+	tracker := newOffsetTracker()
+
+	var commits []int64
+	commit := func(offset int64) func() {
+		return func() { commits = append(commits, offset) }
+	}
+
+	// Offset 0 was never handled when the partition was revoked
+	tracker.track(&Message{Topic: "orders", Offset: 0}, commit(0))
+	tracker.reset()
+
+	// The next owner's records commit without waiting for the forgotten one
+	msg := &Message{Topic: "orders", Offset: 1}
+	tracker.complete(msg, tracker.track(msg, commit(1)))
+
+	if !reflect.DeepEqual(commits, []int64{1}) {
+		t.Errorf("commits = %v, want [1]", commits)
+	}
+}
@@ -1,6 +1,15 @@
 package persistence
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotInitialized is returned when the repository is used before Init
+var ErrNotInitialized = errors.New("database not initialized")
+
+// insertBatchSize is the maximum number of rows written by one multi-row INSERT
+const insertBatchSize = 500
 
 // DBPoolConfig holds configuration for database connection pools
 type DBPoolConfig struct {
@@ -1,9 +1,86 @@
 package persistence
 
+import (
+	"goEvents/internal/domain/model"
+	"time"
+)
+
 // OrderEntity is the database entity for orders
 type OrderEntity struct {
 	ID          uint `gorm:"primaryKey"`
 	Description string
 	Quantity    int
-	Status      string
+	Status      string    `gorm:"index"`
+	CreatedAt   time.Time `gorm:"index"`
+	UpdatedAt   time.Time
+}
+
+// newOrderEntity maps a domain order to its GORM entity
+func newOrderEntity(order *model.Order) *OrderEntity {
+	return &OrderEntity{
+		ID:          order.ID,
+		Description: order.Description,
+		Quantity:    order.Quantity,
+		Status:      string(order.Status),
+		CreatedAt:   order.CreatedAt,
+		UpdatedAt:   order.UpdatedAt,
+	}
+}
+
+// toModel maps the entity back to a domain order
+func (e *OrderEntity) toModel() *model.Order {
+	return &model.Order{
+		ID:          e.ID,
+		Description: e.Description,
+		Quantity:    e.Quantity,
+		Status:      model.OrderStatus(e.Status),
+		CreatedAt:   e.CreatedAt,
+		UpdatedAt:   e.UpdatedAt,
+	}
+}
+
+// OutboxEntity is the database entity for outbox messages
+type OutboxEntity struct {
+	ID          uint64 `gorm:"primaryKey"`
+	AggregateID uint   `gorm:"index"`
+	EventID     string `gorm:"size:36;uniqueIndex"`
+	EventType   string `gorm:"size:64"`
+	Payload     []byte `gorm:"type:json"`
+	Attempts    int
+	LastError   string `gorm:"size:1024"`
+	CreatedAt   time.Time
+	SentAt      *time.Time `gorm:"index"`
+	// DeadAt is set for messages that can never be published, the relay skips them
+	DeadAt *time.Time
+	// CorrelationID and TraceParent are published as record headers by the outbox relay
+	CorrelationID string `gorm:"size:64"`
+	TraceParent   string `gorm:"size:55"`
+}
+
+// newOutboxEntity maps an outbox message to its GORM entity
+func newOutboxEntity(message *model.OutboxMessage) *OutboxEntity {
+	return &OutboxEntity{
+		AggregateID:   message.AggregateID,
+		EventID:       message.EventID,
+		EventType:     message.EventType,
+		Payload:       message.Payload,
+		CreatedAt:     message.CreatedAt,
+		CorrelationID: message.CorrelationID,
+		TraceParent:   message.TraceParent,
+	}
+}
+
+// toModel maps the entity back to an outbox message
+func (e *OutboxEntity) toModel() *model.OutboxMessage {
+	return &model.OutboxMessage{
+		ID:            e.ID,
+		AggregateID:   e.AggregateID,
+		EventID:       e.EventID,
+		EventType:     e.EventType,
+		Payload:       e.Payload,
+		Attempts:      e.Attempts,
+		CreatedAt:     e.CreatedAt,
+		CorrelationID: e.CorrelationID,
+		TraceParent:   e.TraceParent,
+	}
 }
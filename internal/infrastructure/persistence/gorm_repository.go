@@ -1,11 +1,16 @@
 package persistence
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"goEvents/internal/domain/model"
+	"goEvents/internal/domain/repository"
+	"goEvents/internal/infrastructure/messaging/event"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
-	"log"
+	"gorm.io/plugin/opentelemetry/tracing"
+	"time"
 )
 
 // GormRepository implements the domain repository interfaces
@@ -62,10 +67,19 @@ func (r *GormRepository) Init() error {
 	sqlDB.SetConnMaxLifetime(r.poolConfig.ConnMaxLifetime)
 	sqlDB.SetConnMaxIdleTime(r.poolConfig.ConnMaxIdleTime)
 
+	if err := registerDBStats(sqlDB, "gorm"); err != nil {
+		return fmt.Errorf("error registering pool metrics: %w", err)
+	}
+
+	// Trace every SQL statement as a child span of the calling context
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics())); err != nil {
+		return fmt.Errorf("error registering tracing plugin: %w", err)
+	}
+
 	r.db = db
 
-	// Create table if it doesn't exist
-	err = db.AutoMigrate(&OrderEntity{})
+	// Create tables if they don't exist
+	err = db.AutoMigrate(&OrderEntity{}, &OutboxEntity{})
 	if err != nil {
 		return fmt.Errorf("error in AutoMigrate: %w", err)
 	}
@@ -73,28 +87,343 @@ func (r *GormRepository) Init() error {
 	return nil
 }
 
-// SaveOrder saves a new order to the database
-func (r *GormRepository) SaveOrder(order *model.Order) error {
+// SaveOrder saves a new order to the database together with its outbox event
+func (r *GormRepository) SaveOrder(ctx context.Context, order *model.Order) error {
 	// Map domain model to entity
-	entity := &OrderEntity{
-		ID:          order.ID,
-		Description: order.Description,
-		Quantity:    order.Quantity,
-		Status:      order.Status,
+	entity := newOrderEntity(order)
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(entity).Error; err != nil {
+			return err
+		}
+
+		// Update domain model with generated values
+		order.ID = entity.ID
+		order.CreatedAt = entity.CreatedAt
+		order.UpdatedAt = entity.UpdatedAt
+
+		return r.appendOutbox(ctx, tx, event.TypeOrderCreated, order)
+	})
+	if err != nil {
+		logError(ctx, "Error saving order:", err)
+		return err
+	}
+
+	return nil
+}
+
+// SaveOrders saves new orders in batches together with their outbox events
+func (r *GormRepository) SaveOrders(ctx context.Context, orders []*model.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	// Map domain models to entities
+	entities := make([]*OrderEntity, 0, len(orders))
+	for _, order := range orders {
+		entities = append(entities, newOrderEntity(order))
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(entities, insertBatchSize).Error; err != nil {
+			return err
+		}
+
+		// Update domain models with generated values
+		for i, order := range orders {
+			order.ID = entities[i].ID
+			order.CreatedAt = entities[i].CreatedAt
+			order.UpdatedAt = entities[i].UpdatedAt
+		}
+
+		return r.appendOutboxes(ctx, tx, event.TypeOrderCreated, orders)
+	})
+	if err != nil {
+		logError(ctx, "Error saving orders:", err)
+		return err
+	}
+
+	return nil
+}
+
+// FindByID returns the order with the given ID
+func (r *GormRepository) FindByID(ctx context.Context, id uint) (*model.Order, error) {
+	return r.findByID(r.db.WithContext(ctx), id)
+}
+
+// findByID loads an order using the given connection or transaction
+func (r *GormRepository) findByID(db *gorm.DB, id uint) (*model.Order, error) {
+	var entity OrderEntity
+
+	result := db.First(&entity, id)
+	if err := result.Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, model.ErrOrderNotFound
+		}
+		logError(db.Statement.Context, "Error finding order:", err)
+		return nil, err
+	}
+
+	return entity.toModel(), nil
+}
+
+// List returns the orders matching the filter ordered by ID
+func (r *GormRepository) List(ctx context.Context, filter repository.OrderFilter) ([]*model.Order, error) {
+	query := r.db.WithContext(ctx).Model(&OrderEntity{})
+
+	if filter.Status != "" {
+		query = query.Where("status = ?", string(filter.Status))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+
+	var entities []OrderEntity
+	result := query.Order("id").Limit(filter.PageLimit()).Offset(filter.Offset).Find(&entities)
+	if err := result.Error; err != nil {
+		logError(ctx, "Error listing orders:", err)
+		return nil, err
+	}
+
+	orders := make([]*model.Order, 0, len(entities))
+	for i := range entities {
+		orders = append(orders, entities[i].toModel())
+	}
+
+	return orders, nil
+}
+
+// Update persists the description, quantity and status of an existing order
+func (r *GormRepository) Update(ctx context.Context, order *model.Order) error {
+	entity := newOrderEntity(order)
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Select forces zero values to be written as well. The status is left out so a
+		// concurrent UpdateStatus is never reverted to the status read before.
+		result := tx.Model(entity).Select("description", "quantity", "updated_at").Updates(entity)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		// Reloading checks existence, MySQL reports zero affected rows when nothing changed,
+		// and gives the event the stored status
+		stored, err := r.findByID(tx, order.ID)
+		if err != nil {
+			return err
+		}
+
+		order.Status = stored.Status
+		order.UpdatedAt = entity.UpdatedAt
+
+		return r.appendOutbox(ctx, tx, event.TypeOrderUpdated, order)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrOrderNotFound) {
+			logError(ctx, "Error updating order:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// UpdateStatus moves an order from one status to another
+func (r *GormRepository) UpdateStatus(ctx context.Context, id uint, from, to model.OrderStatus) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Only update the row if the status was not changed in the meantime
+		result := tx.Model(&OrderEntity{}).
+			Where("id = ? AND status = ?", id, string(from)).
+			Update("status", string(to))
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return model.ErrConcurrentUpdate
+		}
+
+		order, err := r.findByID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		return r.appendOutbox(ctx, tx, event.TypeOrderStatusChanged, order)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrConcurrentUpdate) {
+			logError(ctx, "Error updating order status:", err)
+		}
+		return err
 	}
 
-	result := r.db.Create(entity)
+	return nil
+}
+
+// UpdateWithStatus persists the fields and the status change of an order in one transaction
+func (r *GormRepository) UpdateWithStatus(ctx context.Context, order *model.Order, from model.OrderStatus) error {
+	entity := newOrderEntity(order)
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Only update the row if the status was not changed in the meantime
+		result := tx.Model(entity).
+			Where("status = ?", string(from)).
+			Select("description", "quantity", "status", "updated_at").
+			Updates(entity)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		// updated_at always changes, so no affected row means the order moved on or is gone
+		if result.RowsAffected == 0 {
+			if _, err := r.findByID(tx, order.ID); err != nil {
+				return err
+			}
+			return model.ErrConcurrentUpdate
+		}
+
+		order.UpdatedAt = entity.UpdatedAt
+
+		if err := r.appendOutbox(ctx, tx, event.TypeOrderStatusChanged, order); err != nil {
+			return err
+		}
+		return r.appendOutbox(ctx, tx, event.TypeOrderUpdated, order)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrOrderNotFound) && !errors.Is(err, model.ErrConcurrentUpdate) {
+			logError(ctx, "Error updating order:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the order with the given ID
+func (r *GormRepository) Delete(ctx context.Context, id uint) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Load the order first so the outbox event carries its last state
+		order, err := r.findByID(tx, id)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Delete(&OrderEntity{}, id)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		if result.RowsAffected == 0 {
+			return model.ErrOrderNotFound
+		}
+
+		return r.appendOutbox(ctx, tx, event.TypeOrderDeleted, order)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrOrderNotFound) {
+			logError(ctx, "Error deleting order:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// appendOutbox writes the outbox event for an order change inside the given transaction
+func (r *GormRepository) appendOutbox(ctx context.Context, tx *gorm.DB, eventType string, order *model.Order) error {
+	return r.appendOutboxes(ctx, tx, eventType, []*model.Order{order})
+}
+
+// appendOutboxes writes one outbox event per order in batches inside the given transaction
+func (r *GormRepository) appendOutboxes(ctx context.Context, tx *gorm.DB, eventType string, orders []*model.Order) error {
+	entities := make([]*OutboxEntity, 0, len(orders))
+	for _, order := range orders {
+		message, err := newOutboxMessage(ctx, eventType, order)
+		if err != nil {
+			return err
+		}
+		entities = append(entities, newOutboxEntity(message))
+	}
+
+	return tx.CreateInBatches(entities, insertBatchSize).Error
+}
+
+// FetchPendingOutbox returns up to limit outbox messages neither sent nor dead, ordered by ID
+func (r *GormRepository) FetchPendingOutbox(ctx context.Context, limit int) ([]*model.OutboxMessage, error) {
+	var entities []OutboxEntity
+
+	result := r.db.WithContext(ctx).Where("sent_at IS NULL AND dead_at IS NULL").Order("id").Limit(limit).Find(&entities)
+	if err := result.Error; err != nil {
+		logError(ctx, "Error fetching outbox messages:", err)
+		return nil, err
+	}
+
+	messages := make([]*model.OutboxMessage, 0, len(entities))
+	for i := range entities {
+		messages = append(messages, entities[i].toModel())
+	}
+
+	return messages, nil
+}
+
+// MarkOutboxSent flags the given outbox messages as published
+func (r *GormRepository) MarkOutboxSent(ctx context.Context, ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	result := r.db.WithContext(ctx).Model(&OutboxEntity{}).Where("id IN ?", ids).Update("sent_at", time.Now())
+	if err := result.Error; err != nil {
+		logError(ctx, "Error marking outbox messages as sent:", err)
+		return err
+	}
+
+	return nil
+}
+
+// MarkOutboxFailed records a failed publish attempt for an outbox message
+func (r *GormRepository) MarkOutboxFailed(ctx context.Context, id uint64, reason string) error {
+	result := r.db.WithContext(ctx).Model(&OutboxEntity{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": truncateOutboxError(reason),
+	})
 	if err := result.Error; err != nil {
-		log.Println("Error saving order:", err)
+		logError(ctx, "Error recording outbox failure:", err)
 		return err
 	}
 
-	// Update domain model with generated ID
-	order.ID = entity.ID
+	return nil
+}
+
+// MarkOutboxDead records why an outbox message can never be published and stops fetching it
+func (r *GormRepository) MarkOutboxDead(ctx context.Context, id uint64, reason string) error {
+	result := r.db.WithContext(ctx).Model(&OutboxEntity{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": truncateOutboxError(reason),
+		"dead_at":    time.Now(),
+	})
+	if err := result.Error; err != nil {
+		logError(ctx, "Error marking outbox message as dead:", err)
+		return err
+	}
 
 	return nil
 }
 
+// Ping checks that a connection to the database can be used
+func (r *GormRepository) Ping(ctx context.Context) error {
+	if r.db == nil {
+		return ErrNotInitialized
+	}
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return fmt.Errorf("error getting underlying DB instance: %w", err)
+	}
+	return sqlDB.PingContext(ctx)
+}
+
 // Close closes the database connection
 func (r *GormRepository) Close() error {
 	if r.db != nil {
@@ -0,0 +1,54 @@
+package persistence
+
+import (
+	"context"
+	"goEvents/internal/correlation"
+	"goEvents/internal/domain/model"
+	"goEvents/internal/infrastructure/messaging/event"
+	"log"
+)
+
+// maxOutboxErrorLength is the size of the last_error column in the outbox tables
+const maxOutboxErrorLength = 1024
+
+// newOutboxMessage encodes the order change as an event ready to be stored in the outbox,
+// along with the correlation ID and trace context of the request that caused it
+func newOutboxMessage(ctx context.Context, eventType string, order *model.Order) (*model.OutboxMessage, error) {
+	evt := event.NewOrderEvent(eventType, order)
+
+	payload, err := event.Encode(evt)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &model.OutboxMessage{
+		AggregateID:   order.ID,
+		EventID:       evt.EventID,
+		EventType:     evt.EventType,
+		Payload:       payload,
+		CreatedAt:     evt.OccurredAt,
+		CorrelationID: correlation.ID(ctx),
+	}
+	if tp, ok := correlation.TraceParentFromContext(ctx); ok {
+		message.TraceParent = tp.String()
+	}
+
+	return message, nil
+}
+
+// truncateOutboxError shortens an error message to fit the last_error column
+func truncateOutboxError(reason string) string {
+	if len(reason) > maxOutboxErrorLength {
+		return reason[:maxOutboxErrorLength]
+	}
+	return reason
+}
+
+// logError logs a database error with the correlation ID of the request, if any
+func logError(ctx context.Context, message string, err error) {
+	if id := correlation.ID(ctx); id != "" {
+		log.Println(message, err, "correlation_id="+id)
+		return
+	}
+	log.Println(message, err)
+}
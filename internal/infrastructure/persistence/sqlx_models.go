@@ -1,13 +1,86 @@
 package persistence
 
+import (
+	"goEvents/internal/domain/model"
+	"time"
+)
+
 // OrderEntitySQLx is the database entity for orders when using SQLx
 type OrderEntitySQLx struct {
-	ID          uint   `db:"id"`
-	Description string `db:"description"`
-	Quantity    int    `db:"quantity"`
-	Status      string `db:"status"`
+	ID          uint      `db:"id"`
+	Description string    `db:"description"`
+	Quantity    int       `db:"quantity"`
+	Status      string    `db:"status"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
 }
 
 func (OrderEntity) TableName() string {
 	return "order_entities"
 }
+
+// newOrderEntitySQLx maps a domain order to its SQLx entity
+func newOrderEntitySQLx(order *model.Order) *OrderEntitySQLx {
+	return &OrderEntitySQLx{
+		ID:          order.ID,
+		Description: order.Description,
+		Quantity:    order.Quantity,
+		Status:      string(order.Status),
+		CreatedAt:   order.CreatedAt,
+		UpdatedAt:   order.UpdatedAt,
+	}
+}
+
+// toModel maps the entity back to a domain order
+func (e *OrderEntitySQLx) toModel() *model.Order {
+	return &model.Order{
+		ID:          e.ID,
+		Description: e.Description,
+		Quantity:    e.Quantity,
+		Status:      model.OrderStatus(e.Status),
+		CreatedAt:   e.CreatedAt,
+		UpdatedAt:   e.UpdatedAt,
+	}
+}
+
+// OutboxEntitySQLx is the database entity for outbox messages when using SQLx
+type OutboxEntitySQLx struct {
+	ID          uint64    `db:"id"`
+	AggregateID uint      `db:"aggregate_id"`
+	EventID     string    `db:"event_id"`
+	EventType   string    `db:"event_type"`
+	Payload     []byte    `db:"payload"`
+	Attempts    int       `db:"attempts"`
+	CreatedAt   time.Time `db:"created_at"`
+	// CorrelationID and TraceParent are published as record headers by the outbox relay
+	CorrelationID string `db:"correlation_id"`
+	TraceParent   string `db:"traceparent"`
+}
+
+// newOutboxEntitySQLx maps an outbox message to its SQLx entity
+func newOutboxEntitySQLx(message *model.OutboxMessage) *OutboxEntitySQLx {
+	return &OutboxEntitySQLx{
+		AggregateID:   message.AggregateID,
+		EventID:       message.EventID,
+		EventType:     message.EventType,
+		Payload:       message.Payload,
+		CreatedAt:     message.CreatedAt,
+		CorrelationID: message.CorrelationID,
+		TraceParent:   message.TraceParent,
+	}
+}
+
+// toModel maps the entity back to an outbox message
+func (e *OutboxEntitySQLx) toModel() *model.OutboxMessage {
+	return &model.OutboxMessage{
+		ID:            e.ID,
+		AggregateID:   e.AggregateID,
+		EventID:       e.EventID,
+		EventType:     e.EventType,
+		Payload:       e.Payload,
+		Attempts:      e.Attempts,
+		CreatedAt:     e.CreatedAt,
+		CorrelationID: e.CorrelationID,
+		TraceParent:   e.TraceParent,
+	}
+}
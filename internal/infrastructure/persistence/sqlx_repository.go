@@ -1,12 +1,20 @@
 package persistence
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"goEvents/internal/domain/model"
-	"log"
+	"goEvents/internal/domain/repository"
+	"goEvents/internal/infrastructure/messaging/event"
+	"strings"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 // SQLxRepository implements the domain repository interfaces using sqlx
@@ -46,17 +54,35 @@ func NewSQLxRepositoryWithConfig(dsn string, poolConfig DBPoolConfig) *SQLxRepos
 
 // Init initializes database connection with connection pool settings
 func (r *SQLxRepository) Init() error {
-	db, err := sqlx.Connect("mysql", r.dsn)
+	// Trace every SQL statement as a child span of the calling context
+	sqlDB, err := otelsql.Open("mysql", r.dsn,
+		otelsql.WithAttributes(semconv.DBSystemMySQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+		}),
+	)
 	if err != nil {
 		return fmt.Errorf("error connecting to database: %w", err)
 	}
 
+	db := sqlx.NewDb(sqlDB, "mysql")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+
 	// Configure pool settings
 	db.SetMaxOpenConns(r.poolConfig.MaxOpenConns)
 	db.SetMaxIdleConns(r.poolConfig.MaxIdleConns)
 	db.SetConnMaxLifetime(r.poolConfig.ConnMaxLifetime)
 	db.SetConnMaxIdleTime(r.poolConfig.ConnMaxIdleTime)
 
+	if err := registerDBStats(sqlDB, "sqlx"); err != nil {
+		return fmt.Errorf("error registering pool metrics: %w", err)
+	}
+
 	r.db = db
 
 	// Create table if it doesn't exist
@@ -65,7 +91,11 @@ func (r *SQLxRepository) Init() error {
 		id INT AUTO_INCREMENT PRIMARY KEY,
 		description VARCHAR(255),
 		quantity INT,
-		status VARCHAR(50)
+		status VARCHAR(50),
+		created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		INDEX idx_order_entity_sqlx_status (status),
+		INDEX idx_order_entity_sqlx_created_at (created_at)
 	);`
 
 	_, err = r.db.Exec(schema)
@@ -73,42 +103,463 @@ func (r *SQLxRepository) Init() error {
 		return fmt.Errorf("error creating table: %w", err)
 	}
 
+	// Create the outbox table written in the same transaction as the orders
+	outboxSchema := `
+	CREATE TABLE IF NOT EXISTS outbox_entity_sqlx (
+		id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+		aggregate_id INT NOT NULL,
+		event_id CHAR(36) NOT NULL,
+		event_type VARCHAR(64) NOT NULL,
+		payload JSON NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		last_error VARCHAR(1024),
+		created_at DATETIME(6) NOT NULL,
+		sent_at DATETIME(6) NULL,
+		dead_at DATETIME(6) NULL,
+		correlation_id VARCHAR(64),
+		traceparent VARCHAR(55),
+		UNIQUE INDEX idx_outbox_entity_sqlx_event_id (event_id),
+		INDEX idx_outbox_entity_sqlx_sent_at (sent_at)
+	);`
+
+	_, err = r.db.Exec(outboxSchema)
+	if err != nil {
+		return fmt.Errorf("error creating outbox table: %w", err)
+	}
+
 	return nil
 }
 
-// SaveOrder saves a new order to the database
-func (r *SQLxRepository) SaveOrder(order *model.Order) error {
+// SaveOrder saves a new order to the database together with its outbox event
+func (r *SQLxRepository) SaveOrder(ctx context.Context, order *model.Order) error {
 	// Map domain model to entity
-	entity := &OrderEntitySQLx{
-		ID:          order.ID,
-		Description: order.Description,
-		Quantity:    order.Quantity,
-		Status:      order.Status,
+	now := time.Now()
+	entity := newOrderEntitySQLx(order)
+	entity.CreatedAt = now
+	entity.UpdatedAt = now
+
+	err := r.withTx(ctx, func(tx *sqlx.Tx) error {
+		// Insert the record
+		query := `INSERT INTO order_entity_sqlx (description, quantity, status, created_at, updated_at) 
+              VALUES (:description, :quantity, :status, :created_at, :updated_at)`
+
+		result, err := tx.NamedExecContext(ctx, query, entity)
+		if err != nil {
+			return err
+		}
+
+		// Get the last inserted ID
+		lastId, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("error getting last inserted ID: %w", err)
+		}
+
+		// Update domain model with generated values
+		order.ID = uint(lastId)
+		order.CreatedAt = entity.CreatedAt
+		order.UpdatedAt = entity.UpdatedAt
+
+		return r.appendOutbox(ctx, tx, event.TypeOrderCreated, order)
+	})
+	if err != nil {
+		logError(ctx, "Error saving order:", err)
+		return err
+	}
+
+	return nil
+}
+
+// SaveOrders saves new orders with multi-row inserts together with their outbox events
+func (r *SQLxRepository) SaveOrders(ctx context.Context, orders []*model.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	// Map domain models to entities
+	now := time.Now()
+	entities := make([]OrderEntitySQLx, 0, len(orders))
+	for _, order := range orders {
+		entity := newOrderEntitySQLx(order)
+		entity.CreatedAt = now
+		entity.UpdatedAt = now
+		entities = append(entities, *entity)
 	}
 
-	// Insert the record
-	query := `INSERT INTO order_entity_sqlx (description, quantity, status) 
-              VALUES (:description, :quantity, :status)`
+	err := r.withTx(ctx, func(tx *sqlx.Tx) error {
+		query := `INSERT INTO order_entity_sqlx (description, quantity, status, created_at, updated_at)
+              VALUES (:description, :quantity, :status, :created_at, :updated_at)`
 
-	result, err := r.db.NamedExec(query, entity)
+		for start := 0; start < len(entities); start += insertBatchSize {
+			end := min(start+insertBatchSize, len(entities))
+
+			result, err := tx.NamedExecContext(ctx, query, entities[start:end])
+			if err != nil {
+				return err
+			}
+
+			// MySQL reports the ID of the first row, the rows of a multi-row INSERT get consecutive IDs
+			firstId, err := result.LastInsertId()
+			if err != nil {
+				return fmt.Errorf("error getting last inserted ID: %w", err)
+			}
+
+			// Update domain models with generated values
+			for i, order := range orders[start:end] {
+				order.ID = uint(firstId) + uint(i)
+				order.CreatedAt = now
+				order.UpdatedAt = now
+			}
+		}
+
+		return r.appendOutboxes(ctx, tx, event.TypeOrderCreated, orders)
+	})
 	if err != nil {
-		log.Println("Error saving order:", err)
+		logError(ctx, "Error saving orders:", err)
 		return err
 	}
 
-	// Get the last inserted ID
-	lastId, err := result.LastInsertId()
+	return nil
+}
+
+// FindByID returns the order with the given ID
+func (r *SQLxRepository) FindByID(ctx context.Context, id uint) (*model.Order, error) {
+	return r.findByID(ctx, r.db, id)
+}
+
+// findByID loads an order using the given connection or transaction
+func (r *SQLxRepository) findByID(ctx context.Context, q sqlx.QueryerContext, id uint) (*model.Order, error) {
+	var entity OrderEntitySQLx
+
+	query := `SELECT id, description, quantity, status, created_at, updated_at
+              FROM order_entity_sqlx WHERE id = ?`
+
+	err := sqlx.GetContext(ctx, q, &entity, query, id)
 	if err != nil {
-		log.Println("Error getting last inserted ID:", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, model.ErrOrderNotFound
+		}
+		logError(ctx, "Error finding order:", err)
+		return nil, err
+	}
+
+	return entity.toModel(), nil
+}
+
+// List returns the orders matching the filter ordered by ID
+func (r *SQLxRepository) List(ctx context.Context, filter repository.OrderFilter) ([]*model.Order, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, string(filter.Status))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.CreatedBefore)
+	}
+
+	query := `SELECT id, description, quantity, status, created_at, updated_at FROM order_entity_sqlx`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id LIMIT ? OFFSET ?"
+	args = append(args, filter.PageLimit(), filter.Offset)
+
+	var entities []OrderEntitySQLx
+	if err := r.db.SelectContext(ctx, &entities, query, args...); err != nil {
+		logError(ctx, "Error listing orders:", err)
+		return nil, err
+	}
+
+	orders := make([]*model.Order, 0, len(entities))
+	for i := range entities {
+		orders = append(orders, entities[i].toModel())
+	}
+
+	return orders, nil
+}
+
+// Update persists the description, quantity and status of an existing order
+func (r *SQLxRepository) Update(ctx context.Context, order *model.Order) error {
+	entity := newOrderEntitySQLx(order)
+	entity.UpdatedAt = time.Now()
+
+	err := r.withTx(ctx, func(tx *sqlx.Tx) error {
+		// The status is left out so a concurrent UpdateStatus is never reverted to the status read before
+		query := `UPDATE order_entity_sqlx
+              SET description = :description, quantity = :quantity, updated_at = :updated_at
+              WHERE id = :id`
+
+		if _, err := tx.NamedExecContext(ctx, query, entity); err != nil {
+			return err
+		}
+
+		// Reloading checks existence, MySQL reports zero affected rows when nothing changed,
+		// and gives the event the stored status
+		stored, err := r.findByID(ctx, tx, order.ID)
+		if err != nil {
+			return err
+		}
+
+		order.Status = stored.Status
+		order.UpdatedAt = entity.UpdatedAt
+
+		return r.appendOutbox(ctx, tx, event.TypeOrderUpdated, order)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrOrderNotFound) {
+			logError(ctx, "Error updating order:", err)
+		}
 		return err
 	}
 
-	// Update domain model with generated ID
-	order.ID = uint(lastId)
+	return nil
+}
+
+// UpdateStatus moves an order from one status to another
+func (r *SQLxRepository) UpdateStatus(ctx context.Context, id uint, from, to model.OrderStatus) error {
+	err := r.withTx(ctx, func(tx *sqlx.Tx) error {
+		// Only update the row if the status was not changed in the meantime
+		query := `UPDATE order_entity_sqlx SET status = ?, updated_at = ? WHERE id = ? AND status = ?`
+
+		result, err := tx.ExecContext(ctx, query, string(to), time.Now(), id, string(from))
+		if err != nil {
+			return err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error getting affected rows: %w", err)
+		}
+
+		if rows == 0 {
+			return model.ErrConcurrentUpdate
+		}
+
+		order, err := r.findByID(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		return r.appendOutbox(ctx, tx, event.TypeOrderStatusChanged, order)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrConcurrentUpdate) {
+			logError(ctx, "Error updating order status:", err)
+		}
+		return err
+	}
 
 	return nil
 }
 
+// UpdateWithStatus persists the fields and the status change of an order in one transaction
+func (r *SQLxRepository) UpdateWithStatus(ctx context.Context, order *model.Order, from model.OrderStatus) error {
+	updatedAt := time.Now()
+
+	err := r.withTx(ctx, func(tx *sqlx.Tx) error {
+		// Only update the row if the status was not changed in the meantime
+		query := `UPDATE order_entity_sqlx
+              SET description = ?, quantity = ?, status = ?, updated_at = ?
+              WHERE id = ? AND status = ?`
+
+		result, err := tx.ExecContext(ctx, query,
+			order.Description, order.Quantity, string(order.Status), updatedAt, order.ID, string(from))
+		if err != nil {
+			return err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error getting affected rows: %w", err)
+		}
+
+		// updated_at always changes, so no affected row means the order moved on or is gone
+		if rows == 0 {
+			if _, err := r.findByID(ctx, tx, order.ID); err != nil {
+				return err
+			}
+			return model.ErrConcurrentUpdate
+		}
+
+		order.UpdatedAt = updatedAt
+
+		if err := r.appendOutbox(ctx, tx, event.TypeOrderStatusChanged, order); err != nil {
+			return err
+		}
+		return r.appendOutbox(ctx, tx, event.TypeOrderUpdated, order)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrOrderNotFound) && !errors.Is(err, model.ErrConcurrentUpdate) {
+			logError(ctx, "Error updating order:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes the order with the given ID
+func (r *SQLxRepository) Delete(ctx context.Context, id uint) error {
+	err := r.withTx(ctx, func(tx *sqlx.Tx) error {
+		// Load the order first so the outbox event carries its last state
+		order, err := r.findByID(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `DELETE FROM order_entity_sqlx WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error getting affected rows: %w", err)
+		}
+
+		if rows == 0 {
+			return model.ErrOrderNotFound
+		}
+
+		return r.appendOutbox(ctx, tx, event.TypeOrderDeleted, order)
+	})
+	if err != nil {
+		if !errors.Is(err, model.ErrOrderNotFound) {
+			logError(ctx, "Error deleting order:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// withTx runs fn inside a transaction, committing on success and rolling back on error
+func (r *SQLxRepository) withTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			logError(ctx, "Error rolling back transaction:", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// appendOutbox writes the outbox event for an order change inside the given transaction
+func (r *SQLxRepository) appendOutbox(ctx context.Context, tx *sqlx.Tx, eventType string, order *model.Order) error {
+	return r.appendOutboxes(ctx, tx, eventType, []*model.Order{order})
+}
+
+// appendOutboxes writes one outbox event per order with multi-row inserts inside the given transaction
+func (r *SQLxRepository) appendOutboxes(ctx context.Context, tx *sqlx.Tx, eventType string, orders []*model.Order) error {
+	entities := make([]OutboxEntitySQLx, 0, len(orders))
+	for _, order := range orders {
+		message, err := newOutboxMessage(ctx, eventType, order)
+		if err != nil {
+			return err
+		}
+		entities = append(entities, *newOutboxEntitySQLx(message))
+	}
+
+	query := `INSERT INTO outbox_entity_sqlx (aggregate_id, event_id, event_type, payload, created_at, correlation_id, traceparent)
+              VALUES (:aggregate_id, :event_id, :event_type, :payload, :created_at, :correlation_id, :traceparent)`
+
+	for start := 0; start < len(entities); start += insertBatchSize {
+		end := min(start+insertBatchSize, len(entities))
+		if _, err := tx.NamedExecContext(ctx, query, entities[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FetchPendingOutbox returns up to limit outbox messages neither sent nor dead, ordered by ID
+func (r *SQLxRepository) FetchPendingOutbox(ctx context.Context, limit int) ([]*model.OutboxMessage, error) {
+	query := `SELECT id, aggregate_id, event_id, event_type, payload, attempts, created_at,
+                     COALESCE(correlation_id, '') AS correlation_id, COALESCE(traceparent, '') AS traceparent
+              FROM outbox_entity_sqlx WHERE sent_at IS NULL AND dead_at IS NULL ORDER BY id LIMIT ?`
+
+	var entities []OutboxEntitySQLx
+	if err := r.db.SelectContext(ctx, &entities, query, limit); err != nil {
+		logError(ctx, "Error fetching outbox messages:", err)
+		return nil, err
+	}
+
+	messages := make([]*model.OutboxMessage, 0, len(entities))
+	for i := range entities {
+		messages = append(messages, entities[i].toModel())
+	}
+
+	return messages, nil
+}
+
+// MarkOutboxSent flags the given outbox messages as published
+func (r *SQLxRepository) MarkOutboxSent(ctx context.Context, ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(`UPDATE outbox_entity_sqlx SET sent_at = ? WHERE id IN (?)`, time.Now(), ids)
+	if err != nil {
+		return fmt.Errorf("error building outbox update: %w", err)
+	}
+
+	if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+		logError(ctx, "Error marking outbox messages as sent:", err)
+		return err
+	}
+
+	return nil
+}
+
+// MarkOutboxFailed records a failed publish attempt for an outbox message
+func (r *SQLxRepository) MarkOutboxFailed(ctx context.Context, id uint64, reason string) error {
+	query := `UPDATE outbox_entity_sqlx SET attempts = attempts + 1, last_error = ? WHERE id = ?`
+
+	if _, err := r.db.ExecContext(ctx, query, truncateOutboxError(reason), id); err != nil {
+		logError(ctx, "Error recording outbox failure:", err)
+		return err
+	}
+
+	return nil
+}
+
+// MarkOutboxDead records why an outbox message can never be published and stops fetching it
+func (r *SQLxRepository) MarkOutboxDead(ctx context.Context, id uint64, reason string) error {
+	query := `UPDATE outbox_entity_sqlx SET attempts = attempts + 1, last_error = ?, dead_at = ? WHERE id = ?`
+
+	if _, err := r.db.ExecContext(ctx, query, truncateOutboxError(reason), time.Now(), id); err != nil {
+		logError(ctx, "Error marking outbox message as dead:", err)
+		return err
+	}
+
+	return nil
+}
+
+// Ping checks that a connection to the database can be used
+func (r *SQLxRepository) Ping(ctx context.Context) error {
+	if r.db == nil {
+		return ErrNotInitialized
+	}
+	return r.db.PingContext(ctx)
+}
+
 // Close closes the database connection
 func (r *SQLxRepository) Close() error {
 	if r.db != nil {
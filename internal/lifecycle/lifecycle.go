@@ -0,0 +1,235 @@
+// Package lifecycle starts the components of the application in dependency order and
+// stops them in reverse order, each within its own deadline
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// ErrInvalidComponent is returned when components cannot be registered or ordered
+var ErrInvalidComponent = errors.New("invalid lifecycle component")
+
+// Component is a part of the application with Start and Stop hooks
+type Component struct {
+	// Name identifies the component in dependencies and reports
+	Name string
+	// DependsOn names the components that must be started before and stopped after this one
+	DependsOn []string
+	// Start starts the component. ctx stays valid while the component runs and is
+	// canceled right before Stop is called, so goroutines can watch it to exit.
+	Start func(ctx context.Context) error
+	// Stop waits for the component to finish. A hook still running when ctx is done is
+	// abandoned and the component reported as failed.
+	Stop func(ctx context.Context) error
+	// StopTimeout bounds Stop, the manager's default is used when zero
+	StopTimeout time.Duration
+}
+
+// ComponentError is the error of one component that failed to stop cleanly
+type ComponentError struct {
+	Component string
+	Err       error
+}
+
+// StopError reports every component that failed to stop cleanly, in stop order
+type StopError struct {
+	Failures []ComponentError
+}
+
+// Error lists the failed components with their errors
+func (e *StopError) Error() string {
+	parts := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		parts = append(parts, fmt.Sprintf("%s: %v", f.Component, f.Err))
+	}
+	return "components failed to stop cleanly: " + strings.Join(parts, "; ")
+}
+
+// Unwrap returns the errors of the failed components
+func (e *StopError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+// running is a started component with the cancel function of its context
+type running struct {
+	component Component
+	cancel    context.CancelFunc
+}
+
+// Manager starts and stops registered components. It is not safe for concurrent use.
+type Manager struct {
+	components  []Component
+	started     []running
+	stopTimeout time.Duration
+}
+
+// NewManager creates a manager giving each component stopTimeout to stop unless it sets its own
+func NewManager(stopTimeout time.Duration) *Manager {
+	return &Manager{stopTimeout: stopTimeout}
+}
+
+// Register adds a component, names must be unique
+func (m *Manager) Register(component Component) error {
+	if component.Name == "" {
+		return fmt.Errorf("%w: a component needs a name", ErrInvalidComponent)
+	}
+	for _, c := range m.components {
+		if c.Name == component.Name {
+			return fmt.Errorf("%w: %s is registered twice", ErrInvalidComponent, component.Name)
+		}
+	}
+
+	m.components = append(m.components, component)
+	return nil
+}
+
+// Start starts all components in dependency order, components without an order between
+// them start in registration order. When a component fails to start, the components
+// started before it are stopped again and the start error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	ordered, err := m.order()
+	if err != nil {
+		return err
+	}
+
+	for _, component := range ordered {
+		// Components outlive the start context and are only canceled when stopped
+		runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+		if component.Start != nil {
+			if err := component.Start(runCtx); err != nil {
+				cancel()
+				startErr := fmt.Errorf("failed to start %s: %w", component.Name, err)
+				if stopErr := m.Stop(context.WithoutCancel(ctx)); stopErr != nil {
+					return errors.Join(startErr, stopErr)
+				}
+				return startErr
+			}
+		}
+
+		m.started = append(m.started, running{component: component, cancel: cancel})
+		logrus.WithField("component", component.Name).Info("Component started")
+	}
+
+	return nil
+}
+
+// Stop stops the started components in reverse start order. Each component's context
+// is canceled and its Stop hook gets its own deadline; a component that does not stop
+// in time is reported and left behind so the others can still stop. The returned
+// *StopError lists every component that failed.
+func (m *Manager) Stop(ctx context.Context) error {
+	var failures []ComponentError
+
+	for i := len(m.started) - 1; i >= 0; i-- {
+		r := m.started[i]
+		r.cancel()
+
+		if err := m.stop(ctx, r.component); err != nil {
+			logrus.WithError(err).WithField("component", r.component.Name).Error("Component failed to stop cleanly")
+			failures = append(failures, ComponentError{Component: r.component.Name, Err: err})
+			continue
+		}
+		logrus.WithField("component", r.component.Name).Info("Component stopped")
+	}
+	m.started = nil
+
+	if len(failures) > 0 {
+		return &StopError{Failures: failures}
+	}
+	return nil
+}
+
+// stop runs the Stop hook of a component within its deadline
+func (m *Manager) stop(ctx context.Context, component Component) error {
+	if component.Stop == nil {
+		return nil
+	}
+
+	timeout := component.StopTimeout
+	if timeout <= 0 {
+		timeout = m.stopTimeout
+	}
+	stopCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// The hook runs on its own so a hook ignoring its context cannot block the others
+	done := make(chan error, 1)
+	go func() {
+		done <- component.Stop(stopCtx)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil && stopCtx.Err() != nil {
+			// Hooks that give up at the deadline may not report it themselves
+			return fmt.Errorf("did not stop within %s: %w", timeout, stopCtx.Err())
+		}
+		return err
+	case <-stopCtx.Done():
+		return fmt.Errorf("did not stop within %s: %w", timeout, stopCtx.Err())
+	}
+}
+
+// order sorts the components so every component comes after its dependencies
+func (m *Manager) order() ([]Component, error) {
+	byName := make(map[string]Component, len(m.components))
+	for _, c := range m.components {
+		byName[c.Name] = c
+	}
+	for _, c := range m.components {
+		for _, dep := range c.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("%w: %s depends on unknown component %s", ErrInvalidComponent, c.Name, dep)
+			}
+		}
+	}
+
+	ordered := make([]Component, 0, len(m.components))
+	placed := make(map[string]bool, len(m.components))
+
+	// Each pass places the first component whose dependencies are all placed
+	for len(ordered) < len(m.components) {
+		progress := false
+		for _, c := range m.components {
+			if placed[c.Name] || !dependenciesPlaced(c, placed) {
+				continue
+			}
+			ordered = append(ordered, c)
+			placed[c.Name] = true
+			progress = true
+			break
+		}
+
+		if !progress {
+			var cycle []string
+			for _, c := range m.components {
+				if !placed[c.Name] {
+					cycle = append(cycle, c.Name)
+				}
+			}
+			return nil, fmt.Errorf("%w: dependency cycle among %s", ErrInvalidComponent, strings.Join(cycle, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+// dependenciesPlaced reports whether all dependencies of a component were placed
+func dependenciesPlaced(c Component, placed map[string]bool) bool {
+	for _, dep := range c.DependsOn {
+		if !placed[dep] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,312 @@
+// Package loadgen publishes order events through a MessageProducer at a target rate and
+// reports the throughput and publish latency
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goEvents/internal/domain/model"
+	"goEvents/internal/infrastructure/messaging"
+	"goEvents/internal/infrastructure/messaging/event"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidConfig is returned for a load configuration that cannot be run
+var ErrInvalidConfig = errors.New("invalid load generator configuration")
+
+// KeyDistribution selects the order IDs, and so the record keys, of the published events
+type KeyDistribution string
+
+const (
+	// KeysUnique gives every event its own key
+	KeysUnique KeyDistribution = "unique"
+	// KeysUniform picks keys uniformly from the key space
+	KeysUniform KeyDistribution = "uniform"
+	// KeysSequential cycles through the key space
+	KeysSequential KeyDistribution = "sequential"
+	// KeysZipf picks few hot keys often and most keys rarely
+	KeysZipf KeyDistribution = "zipf"
+)
+
+// zipfSkew is the exponent of the zipf distribution, larger values concentrate on fewer keys
+const zipfSkew = 1.1
+
+// Config holds the settings of a load run. Count or Duration must be set, the run stops
+// at whichever is reached first.
+type Config struct {
+	// Rate is the target of published events per second across all workers, zero for no limit
+	Rate float64
+	// Count is the number of events to publish, zero for no limit
+	Count int
+	// Duration bounds the run, zero for no limit
+	Duration time.Duration
+	// MessageSize is the approximate size of an encoded event in bytes
+	MessageSize int
+	// Keys selects how record keys are distributed
+	Keys KeyDistribution
+	// KeySpace is the number of distinct keys of the uniform, sequential and zipf distributions
+	KeySpace int
+	// Concurrency is the number of events published in parallel
+	Concurrency int
+}
+
+// DefaultConfig returns the settings of the former built-in benchmark: 100,000 events as
+// fast as ten workers can publish them
+func DefaultConfig() Config {
+	return Config{
+		Count:       100000,
+		MessageSize: 256,
+		Keys:        KeysUniform,
+		KeySpace:    1000,
+		Concurrency: 10,
+	}
+}
+
+// Validate checks the configuration
+func (c Config) Validate() error {
+	if c.Count <= 0 && c.Duration <= 0 {
+		return fmt.Errorf("%w: a count or a duration is required", ErrInvalidConfig)
+	}
+	if c.Count < 0 || c.Duration < 0 || c.Rate < 0 || c.MessageSize < 0 {
+		return fmt.Errorf("%w: rate, count, duration and message size must not be negative", ErrInvalidConfig)
+	}
+	if c.Concurrency <= 0 {
+		return fmt.Errorf("%w: concurrency must be positive", ErrInvalidConfig)
+	}
+
+	switch c.Keys {
+	case KeysUnique:
+	case KeysUniform, KeysSequential, KeysZipf:
+		if c.KeySpace <= 0 {
+			return fmt.Errorf("%w: key space must be positive for %s keys", ErrInvalidConfig, c.Keys)
+		}
+	default:
+		return fmt.Errorf("%w: unknown key distribution %q, expected %q, %q, %q or %q",
+			ErrInvalidConfig, c.Keys, KeysUnique, KeysUniform, KeysSequential, KeysZipf)
+	}
+
+	return nil
+}
+
+// Report is the result of a load run
+type Report struct {
+	// Sent counts the events the producer published successfully
+	Sent int
+	// Failed counts the events the producer returned an error for
+	Failed int
+	// Bytes is the encoded size of the sent events
+	Bytes int64
+	// Elapsed is the time from the first publish until the last one returned
+	Elapsed time.Duration
+	// Throughput is the number of sent events per second
+	Throughput float64
+	// P50, P95, P99 and Max are publish latencies of the sent events
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+	Max time.Duration
+	// Err is the first publish error, if any
+	Err error
+}
+
+// String returns a human-readable summary of the report
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sent=%d failed=%d elapsed=%s\n", r.Sent, r.Failed, r.Elapsed.Round(time.Millisecond))
+	var mibPerSecond float64
+	if r.Elapsed > 0 {
+		mibPerSecond = float64(r.Bytes) / r.Elapsed.Seconds() / (1 << 20)
+	}
+	fmt.Fprintf(&b, "throughput=%.1f msg/s %.2f MiB/s\n", r.Throughput, mibPerSecond)
+	fmt.Fprintf(&b, "latency p50=%s p95=%s p99=%s max=%s\n", r.P50, r.P95, r.P99, r.Max)
+	if r.Err != nil {
+		fmt.Fprintf(&b, "first error: %v\n", r.Err)
+	}
+	return b.String()
+}
+
+// worker holds the results of one publishing goroutine
+type worker struct {
+	latencies []time.Duration
+	failed    int
+	bytes     int64
+	err       error
+}
+
+// Run publishes events through the producer until the count or duration is reached or
+// ctx is canceled, and reports what was published until then
+func Run(ctx context.Context, producer messaging.MessageProducer, config Config) (*Report, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := producer.Initialize(); err != nil {
+		return nil, err
+	}
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+
+	description, size, err := padding(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every event takes the next sequence number, which schedules it and may select its key
+	var sequence atomic.Int64
+	workers := make([]*worker, config.Concurrency)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := &worker{}
+		workers[i] = w
+		keys := newKeyGenerator(config, rand.New(rand.NewSource(time.Now().UnixNano()+int64(i))))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				n := sequence.Add(1) - 1
+				if config.Count > 0 && n >= int64(config.Count) {
+					return
+				}
+				if !waitTurn(ctx, start, n, config.Rate) {
+					return
+				}
+
+				evt := event.NewOrderEvent(event.TypeOrderPlaced, &model.Order{
+					ID:          keys(n),
+					Description: description,
+					Quantity:    1,
+					Status:      model.StatusPending,
+				})
+
+				sentAt := time.Now()
+				err := producer.PublishOrder(ctx, evt)
+				latency := time.Since(sentAt)
+
+				if err != nil {
+					// Publishes cut off by the end of the run are not failures
+					if ctx.Err() != nil {
+						return
+					}
+					w.failed++
+					if w.err == nil {
+						w.err = err
+					}
+					continue
+				}
+				w.latencies = append(w.latencies, latency)
+				w.bytes += int64(size)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return newReport(workers, time.Since(start)), nil
+}
+
+// waitTurn waits until event n is due at the target rate, false once ctx is done
+func waitTurn(ctx context.Context, start time.Time, n int64, rate float64) bool {
+	if rate <= 0 {
+		return ctx.Err() == nil
+	}
+
+	delay := time.Until(start.Add(time.Duration(float64(n) / rate * float64(time.Second))))
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// newKeyGenerator returns the order ID of event n, zero gives the event its ID as key
+func newKeyGenerator(config Config, r *rand.Rand) func(n int64) uint {
+	switch config.Keys {
+	case KeysUniform:
+		return func(int64) uint { return uint(r.Intn(config.KeySpace)) + 1 }
+	case KeysSequential:
+		return func(n int64) uint { return uint(n%int64(config.KeySpace)) + 1 }
+	case KeysZipf:
+		zipf := rand.NewZipf(r, zipfSkew, 1, uint64(config.KeySpace-1))
+		return func(int64) uint { return uint(zipf.Uint64()) + 1 }
+	default:
+		return func(int64) uint { return 0 }
+	}
+}
+
+// padding returns the description that brings an encoded event close to the message size,
+// and that size. Events smaller than the message size cannot be produced.
+func padding(config Config) (string, int, error) {
+	base, err := event.Encode(event.NewOrderEvent(event.TypeOrderPlaced, &model.Order{
+		ID:       uint(config.KeySpace),
+		Quantity: 1,
+		Status:   model.StatusPending,
+	}))
+	if err != nil {
+		return "", 0, err
+	}
+
+	if config.MessageSize <= len(base) {
+		return "", len(base), nil
+	}
+	return strings.Repeat("x", config.MessageSize-len(base)), config.MessageSize, nil
+}
+
+// newReport merges the results of the workers
+func newReport(workers []*worker, elapsed time.Duration) *Report {
+	report := &Report{Elapsed: elapsed}
+
+	var latencies []time.Duration
+	for _, w := range workers {
+		latencies = append(latencies, w.latencies...)
+		report.Failed += w.failed
+		report.Bytes += w.bytes
+		if report.Err == nil {
+			report.Err = w.err
+		}
+	}
+	report.Sent = len(latencies)
+
+	if elapsed > 0 {
+		report.Throughput = float64(report.Sent) / elapsed.Seconds()
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.P50 = percentile(latencies, 50)
+	report.P95 = percentile(latencies, 95)
+	report.P99 = percentile(latencies, 99)
+	report.Max = percentile(latencies, 100)
+
+	return report
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
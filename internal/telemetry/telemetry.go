@@ -0,0 +1,145 @@
+// Package telemetry configures OpenTelemetry tracing for the service
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"strings"
+)
+
+// Exporter selects where finished spans are sent
+type Exporter string
+
+const (
+	// ExporterOTLP sends spans to an OTLP/HTTP collector
+	ExporterOTLP Exporter = "otlp"
+	// ExporterStdout writes spans as JSON to stdout
+	ExporterStdout Exporter = "stdout"
+	// ExporterNone records no spans; trace context is still propagated
+	ExporterNone Exporter = "none"
+)
+
+// DefaultServiceName is the service.name resource attribute used when none is configured
+const DefaultServiceName = "go-events"
+
+// instrumentationName names the tracer used by the service's own spans
+const instrumentationName = "goEvents"
+
+// Config holds the tracing configuration
+type Config struct {
+	// Exporter selects the span exporter, ExporterNone when empty
+	Exporter Exporter
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+	// OTLPEndpoint is the host:port of the OTLP/HTTP collector. When empty the
+	// exporter reads OTEL_EXPORTER_OTLP_ENDPOINT, or uses localhost:4318.
+	OTLPEndpoint string
+	// OTLPInsecure disables TLS towards the collector
+	OTLPInsecure bool
+}
+
+// ConfigFromEnv reads the configuration from the standard OTEL_TRACES_EXPORTER and
+// OTEL_SERVICE_NAME environment variables. "console" is accepted as an alias of stdout.
+func ConfigFromEnv() Config {
+	exporter := Exporter(strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")))
+	if exporter == "console" {
+		exporter = ExporterStdout
+	}
+
+	return Config{
+		Exporter:     exporter,
+		ServiceName:  os.Getenv("OTEL_SERVICE_NAME"),
+		OTLPInsecure: os.Getenv("OTEL_EXPORTER_OTLP_INSECURE") == "true",
+	}
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator.
+// The returned function flushes pending spans and must be called on shutdown.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	if config.ServiceName == "" {
+		config.ServiceName = DefaultServiceName
+	}
+
+	// Trace context travels in traceparent headers whatever the exporter
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, err := newExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		logrus.Info("Tracing disabled, no span exporter configured")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	provider := NewTracerProvider(config.ServiceName, sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+
+	logrus.WithFields(logrus.Fields{
+		"exporter":     config.Exporter,
+		"service_name": config.ServiceName,
+	}).Info("Tracing enabled")
+
+	return provider.Shutdown, nil
+}
+
+// NewTracerProvider creates a tracer provider sampling every trace and reporting the
+// given service name. Options add span processors, such as sdktrace.WithBatcher or
+// sdktrace.WithSyncer with an in-memory exporter.
+func NewTracerProvider(serviceName string, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	res := resource.NewSchemaless(semconv.ServiceName(serviceName))
+
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	}, opts...)
+
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// Tracer returns the tracer for the service's own spans from the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// newExporter creates the configured span exporter, nil for ExporterNone
+func newExporter(ctx context.Context, config Config) (sdktrace.SpanExporter, error) {
+	switch config.Exporter {
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if config.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.OTLPEndpoint))
+		}
+		if config.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating OTLP exporter: %w", err)
+		}
+		return exporter, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("error creating stdout exporter: %w", err)
+		}
+		return exporter, nil
+	case ExporterNone, "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected otlp, stdout or none", config.Exporter)
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"github.com/sirupsen/logrus"
+	"goEvents/internal/config"
 	"goEvents/internal/domain/service"
 	"goEvents/internal/infrastructure/api"
 	"goEvents/internal/infrastructure/messaging"
 	"goEvents/internal/infrastructure/persistence"
+	"goEvents/internal/lifecycle"
+	"goEvents/internal/telemetry"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 )
@@ -19,6 +24,16 @@ func main() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetLevel(logrus.InfoLevel)
 
+	// Load the configuration from the YAML file, GOEVENTS_* variables and flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to load configuration")
+	}
+	logrus.WithFields(cfg.Redacted()).Info("Effective configuration")
+
 	// Create a context that will be canceled on SIGINT or SIGTERM
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -34,86 +49,157 @@ func main() {
 		cancel() // Cancel the context, triggering graceful shutdown
 	}()
 
+	// Components start in dependency order and stop in reverse order, each within the shutdown timeout
+	manager := lifecycle.NewManager(cfg.HTTP.ShutdownTimeout)
+
+	// Tracing starts first and stops last so every component's spans are flushed
+	var shutdownTracing func(context.Context) error
+	register(manager, lifecycle.Component{
+		Name: "tracing",
+		Start: func(ctx context.Context) error {
+			// The exporter is selected with OTEL_TRACES_EXPORTER (otlp, stdout or none)
+			var err error
+			shutdownTracing, err = telemetry.Setup(ctx, telemetry.ConfigFromEnv())
+			return err
+		},
+		Stop: func(ctx context.Context) error {
+			return shutdownTracing(ctx)
+		},
+	})
+
 	// Initialize infrastructure layer - database
-	repository := persistence.NewSQLxRepository("")
-	err := repository.Init()
-	if err != nil {
-		logrus.Fatalf("Failed to initialize database: %v", err)
-	}
+	repository := persistence.NewSQLxRepositoryWithConfig(cfg.Database.DSN, cfg.PoolConfig())
+	register(manager, lifecycle.Component{
+		Name:      "repository",
+		DependsOn: []string{"tracing"},
+		Start: func(context.Context) error {
+			return repository.Init()
+		},
+		Stop: func(context.Context) error {
+			return repository.Close()
+		},
+	})
 
 	// Initialize domain layer - services
 	orderService := service.NewOrderService(repository)
 
-	// Create Kafka configuration
-	kafkaConfig := &messaging.ConsumerConfig{
-		BootstrapServers: "localhost:9092",
-		GroupID:          "order.group",
-		Topics:           []string{"orders"},
-		AutoOffsetReset:  "earliest",
-	}
-
 	// Initialize infrastructure layer - Kafka
-	producer := messaging.NewFranzKafkaProducer("")
-	if err := producer.Initialize(); err != nil {
-		logrus.WithError(err).Fatal("Failed to initialize Kafka producer")
+	producer, err := messaging.NewProducer(cfg.Kafka.Client, cfg.ProducerConfig())
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to create Kafka producer")
+	}
+	register(manager, lifecycle.Component{
+		Name:      "producer",
+		DependsOn: []string{"tracing"},
+		Start: func(context.Context) error {
+			return producer.Initialize()
+		},
+		Stop: func(ctx context.Context) error {
+			producer.Shutdown(ctx)
+			return nil
+		},
+	})
+
+	// Route consumed order events to the order service
+	orderEventHandler := messaging.NewOrderEventHandler(orderService)
+	messageRouter := messaging.NewRouter()
+	for _, topic := range cfg.Kafka.Consumer.Topics {
+		messageRouter.HandleTopic(topic, orderEventHandler)
 	}
 
-	consumer := messaging.NewFranzKafkaConsumer(orderService, kafkaConfig)
-
-	// Start multiple consumers with context
-	var wg sync.WaitGroup
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func(consumerIndex int) {
-			defer wg.Done()
-			logrus.WithField("consumer_index", consumerIndex).Info("Starting consumer")
-			consumer.Start(ctx)
-		}(i)
+	consumer, err := messaging.NewConsumer(cfg.Kafka.Client, messageRouter, cfg.ConsumerConfig())
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to create Kafka consumer")
 	}
+	register(manager, lifecycle.Component{
+		Name:      "consumer",
+		DependsOn: []string{"repository"},
+		Start: func(ctx context.Context) error {
+			// Records are processed by the consumer's worker pool until ctx is canceled
+			consumer.Start(ctx)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			consumer.Wait()
+			return nil
+		},
+	})
+
+	// Relay order events written to the outbox by the repository
+	outboxRelay := messaging.NewOutboxRelay(repository, producer, messaging.DefaultOutboxRelayConfig())
+	register(manager, lifecycle.Component{
+		Name:      "outbox-relay",
+		DependsOn: []string{"repository", "producer"},
+		Start: func(ctx context.Context) error {
+			outboxRelay.Start(ctx)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			outboxRelay.Wait()
+			return nil
+		},
+	})
 
 	// Initialize infrastructure layer - API
-	handler := api.NewHandler(orderService, producer)
+	handler := api.NewHandler(orderService, repository, producer, map[string]messaging.MessageConsumer{
+		"orders": consumer,
+	})
 	router := api.SetupRouter(handler)
 
 	// Create HTTP server with the router
 	srv := &http.Server{
-		Addr:    ":8081",
-		Handler: router,
+		Addr:              cfg.HTTP.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+	}
+	register(manager, lifecycle.Component{
+		Name:      "http",
+		DependsOn: []string{"repository", "producer", "consumer"},
+		Start: func(context.Context) error {
+			// Listen before returning so a busy port fails the startup
+			listener, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+
+			go func() {
+				logrus.WithField("addr", cfg.HTTP.Addr).Info("Starting HTTP server")
+				if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+					logrus.WithError(err).Error("HTTP server failed")
+				}
+			}()
+			return nil
+		},
+		Stop: srv.Shutdown,
+	})
+
+	if err := manager.Start(ctx); err != nil {
+		logrus.WithError(err).Fatal("Failed to start application")
 	}
-
-	// Start HTTP server in a separate goroutine
-	go func() {
-		logrus.Info("Starting HTTP server on :8081")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logrus.WithError(err).Fatal("Could not start HTTP server")
-		}
-	}()
 
 	// Wait for context cancelation (from OS signals)
 	<-ctx.Done()
 	logrus.Info("Shutting down application...")
 
-	// Create a timeout context for shutdowns
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer shutdownCancel()
-
-	// Shutdown HTTP server gracefully
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		logrus.WithError(err).Error("HTTP server shutdown error")
-	} else {
-		logrus.Info("HTTP server shutdown complete")
+	// Readiness fails first so load balancers stop routing requests before the server drains
+	handler.Drain()
+	if cfg.HTTP.DrainDelay > 0 {
+		logrus.WithField("drain_delay", cfg.HTTP.DrainDelay.String()).Info("Draining before shutdown")
+		time.Sleep(cfg.HTTP.DrainDelay)
 	}
 
-	// Shutdown Kafka producer
-	logrus.Info("Shutting down Kafka producer")
-	producer.Shutdown(shutdownCtx)
-
-	// Wait for all consumers to finish
-	logrus.Info("Waiting for all Kafka consumers to finish")
-	consumer.Wait()
-
-	// Wait for any remaining goroutines
-	wg.Wait()
+	// The HTTP server stops first and the repository and tracing last
+	if err := manager.Stop(context.Background()); err != nil {
+		logrus.WithError(err).Error("Application shutdown incomplete")
+		os.Exit(1)
+	}
 
 	logrus.Info("Application shutdown completed")
 }
+
+// register adds a component to the manager, registration only fails on programming errors
+func register(manager *lifecycle.Manager, component lifecycle.Component) {
+	if err := manager.Register(component); err != nil {
+		logrus.WithError(err).Fatal("Failed to register component")
+	}
+}